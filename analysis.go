@@ -0,0 +1,79 @@
+/*
+ * Static analysis of Intcode program images
+ */
+
+package main
+
+import (
+	"fmt"
+)
+
+// staticInstruction is an instruction decoded from a program image without running it
+type staticInstruction struct {
+	address int          // address of the instruction's opcode
+	inst    *instruction // decoded instruction
+	modes   paramModes   // modes of the instruction's parameters
+	params  []int        // raw values of the instruction's parameters
+}
+
+// end returns the address just past the instruction
+func (si staticInstruction) end() int {
+	return si.address + si.inst.width
+}
+
+// writeTarget returns the address written by the instruction's parameter at the passed index
+// as it stands in the image
+func (si staticInstruction) writeTarget(param int) int {
+	if si.modes[param] == ImmediateMode {
+		return si.address + 1 + param
+	}
+	return si.params[param]
+}
+
+// disassemble formats a statically decoded instruction in the style of the VM's verbose output
+func disassemble(si staticInstruction) string {
+	text := si.inst.mnemonic
+	for param, val := range si.params {
+		if si.modes[param] == ImmediateMode {
+			text += fmt.Sprintf(" $%d", val)
+		} else {
+			text += fmt.Sprintf(" [%d]", val)
+		}
+	}
+	return text
+}
+
+// decodeAt decodes the instruction at the passed address of an image reporting false if the
+// value there is not a valid instruction or its parameters run past the end of the image
+func decodeAt(image []int, address int) (staticInstruction, bool) {
+	if address < 0 || address >= len(image) {
+		return staticInstruction{}, false
+	}
+	inst, modes, ok := decode(image[address])
+	if !ok || address+inst.width > len(image) {
+		return staticInstruction{}, false
+	}
+	return staticInstruction{
+		address: address,
+		inst:    inst,
+		modes:   modes,
+		params:  image[address+1 : address+inst.width],
+	}, true
+}
+
+// sweep decodes the instructions of an image in execution order starting at address 0 and
+// stopping after a halt or at a value that is not a valid instruction
+func sweep(image []int) []staticInstruction {
+	code := []staticInstruction{}
+	for address := 0; ; {
+		si, ok := decodeAt(image, address)
+		if !ok {
+			return code
+		}
+		code = append(code, si)
+		if si.inst.halts {
+			return code
+		}
+		address = si.end()
+	}
+}
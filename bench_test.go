@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// legacyDecode is the string based decoder the VM used before decoding moved to integer
+// arithmetic, kept to show the difference
+func legacyDecode(val int) (int, paramModes, error) {
+	var modes paramModes
+	directive := fmt.Sprintf("%5d", val)
+	for param := 0; param < maxParams; param++ {
+		switch directive[param] {
+		case ' ', '0':
+			modes[maxParams-1-param] = PositionMode
+		case '1':
+			modes[maxParams-1-param] = ImmediateMode
+		default:
+			return 0, modes, fmt.Errorf("Encountered invalid directive '%v'", directive[param])
+		}
+	}
+	opcode, err := strconv.Atoi(strings.TrimLeft(directive[3:], " "))
+	if err != nil {
+		return 0, modes, err
+	}
+	return opcode, modes, nil
+}
+
+// loadImage returns the memory of a program loaded from a file
+func loadImage(b *testing.B, fileName string) []int {
+	b.Helper()
+	vm, err := new(VM).Load(fileName)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return vm.memory
+}
+
+// benchmarkDecode decodes every cell of the day 02 and day 05 programs with the passed decoder
+func benchmarkDecode(b *testing.B, decoder func(val int)) {
+	images := [][]int{loadImage(b, "data/day02.txt"), loadImage(b, "data/day05.txt")}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, image := range images {
+			for _, val := range image {
+				decoder(val)
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeTable(b *testing.B) {
+	benchmarkDecode(b, func(val int) { decode(val) })
+}
+
+func BenchmarkDecodeLegacy(b *testing.B) {
+	benchmarkDecode(b, func(val int) { legacyDecode(val) })
+}
+
+// benchmarkRun repeatedly runs the day 02 program on one VM, restoring its memory and applying
+// the noun and verb of problem 02 before each run
+func benchmarkRun(b *testing.B, engine Engine) {
+	image := loadImage(b, "data/day02.txt")
+	vm := &VM{engine: engine}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		vm.restore(image)
+		vm.ModeWrite(1, 12, ImmediateMode)
+		vm.ModeWrite(2, 2, ImmediateMode)
+		if err := vm.Run(false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRunInterpreter(b *testing.B) {
+	benchmarkRun(b, InterpreterEngine)
+}
+
+func BenchmarkRunThreaded(b *testing.B) {
+	benchmarkRun(b, ThreadedEngine)
+}
@@ -0,0 +1,339 @@
+/*
+ * Control-flow graph extraction for Intcode program images
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// cfgEdge is a transfer of control from the instruction at one address to another
+type cfgEdge struct {
+	from int
+	to   int
+}
+
+// Trace records the control transfers made while a VM ran so that jumps whose targets cannot
+// be determined statically can be added to a control-flow graph
+type Trace struct {
+	NopObserver
+	edges map[cfgEdge]int // number of times control passed along each edge
+	code  map[int][]int   // cells of the instruction last executed at each address
+	last  int             // address of the instruction last executed in the current run, -1 if none
+}
+
+// record notes a transfer of control from one instruction to the next
+func (t *Trace) record(from, to int) {
+	t.edges[cfgEdge{from, to}]++
+}
+
+// OnStep records the transfer of control to the instruction about to be executed and the
+// instruction itself, which the program may have written since it was loaded
+func (t *Trace) OnStep(vm *VM, step Step) {
+	if t.last >= 0 {
+		t.record(t.last, step.IP)
+	}
+	t.last = step.IP
+	end := step.IP + step.Params() + 1
+	if end > vm.Size() {
+		end = vm.Size() // the instruction is truncated and will fault
+	}
+	cells := vm.memory[step.IP:end]
+	if !equalMemory(t.code[step.IP], cells) {
+		t.code[step.IP] = append([]int(nil), cells...)
+	}
+}
+
+// OnHalt records a final transfer of control to an address where no instruction could be
+// executed and readies the trace for the next run
+func (t *Trace) OnHalt(vm *VM, err error) {
+	if t.last >= 0 && vm.IP() != t.last {
+		t.record(t.last, vm.IP())
+	}
+	t.last = -1
+}
+
+// EnableTrace attaches a fresh trace to the VM and returns it so that subsequent runs record
+// their control transfers
+func (vm *VM) EnableTrace() *Trace {
+	vm.DisableTrace()
+	vm.trace = &Trace{edges: map[cfgEdge]int{}, code: map[int][]int{}, last: -1}
+	vm.AddObserver(vm.trace)
+	return vm.trace
+}
+
+// DisableTrace detaches any trace from the VM
+func (vm *VM) DisableTrace() {
+	if vm.trace != nil {
+		vm.RemoveObserver(vm.trace)
+		vm.trace = nil
+	}
+}
+
+// Trace returns the trace attached to the VM or nil if tracing is not enabled
+func (vm *VM) Trace() *Trace {
+	return vm.trace
+}
+
+// BasicBlock is a run of instructions entered only at its first and left only after its last
+type BasicBlock struct {
+	Start      int          // address of the first instruction
+	End        int          // address just past the last instruction
+	Successors []int        // addresses of the blocks control may pass to next
+	Dynamic    map[int]bool // successors known only from a trace
+	Computed   bool         // whether the block ends in a jump with a target unknown statically
+	Halts      bool         // whether the block ends in a halt
+	code       []staticInstruction
+}
+
+// CFG is the control-flow graph of a program image
+type CFG struct {
+	Blocks []*BasicBlock // blocks ordered by address
+	image  []int
+}
+
+// successorsOf returns the statically known successors of an instruction and whether it ends
+// in a jump with a target that cannot be determined statically
+func successorsOf(si staticInstruction) ([]int, bool) {
+	switch {
+	case si.inst.halts:
+		return nil, false
+	case si.inst.jump == nil:
+		return []int{si.end()}, false
+	}
+	succs := []int{}
+	computed := true
+	if si.modes[si.inst.jump.target] == ImmediateMode {
+		succs = append(succs, si.params[si.inst.jump.target])
+		computed = false
+	}
+	if si.inst.jump.conditional {
+		succs = append(succs, si.end())
+	}
+	return succs, computed
+}
+
+// BuildCFG extracts the control-flow graph of an image starting from address 0.  If a trace
+// is passed, the transfers it recorded are added to those found statically so that computed
+// jumps and code reached only through self-modification are included, and cells holding no
+// valid instruction in the image are decoded as the instruction the trace saw executed there.
+// Any further roots passed, such as the address a paused VM will resume at, start blocks of
+// their own when they are not reached from the others.
+func BuildCFG(image []int, trace *Trace, roots ...int) *CFG {
+	if trace != nil {
+		image = trace.overlay(image)
+	}
+
+	// Find every instruction reachable statically or seen in the trace
+	code := map[int]staticInstruction{}
+	leaders := map[int]bool{0: true}
+	endsBlock := map[int]bool{}
+	dynamic := map[int][]int{}
+	walk := func(work []int) {
+		for len(work) > 0 {
+			address := work[len(work)-1]
+			work = work[:len(work)-1]
+			if _, ok := code[address]; ok {
+				continue
+			}
+			si, ok := decodeAt(image, address)
+			if !ok {
+				continue
+			}
+			code[address] = si
+			succs, computed := successorsOf(si)
+			if si.inst.halts || si.inst.jump != nil || computed {
+				endsBlock[address] = true
+			}
+			for _, succ := range succs {
+				if si.inst.jump != nil {
+					leaders[succ] = true
+				}
+				work = append(work, succ)
+			}
+		}
+	}
+	work := []int{0}
+	if trace != nil {
+		for edge := range trace.edges {
+			work = append(work, edge.from, edge.to)
+			dynamic[edge.from] = append(dynamic[edge.from], edge.to)
+		}
+	}
+	walk(work)
+	for _, root := range roots {
+		if _, ok := code[root]; !ok {
+			leaders[root] = true
+			walk([]int{root})
+		}
+	}
+
+	// Transfers seen in the trace that do not simply continue with the next instruction
+	// split blocks just as jumps do
+	for from, tos := range dynamic {
+		for _, to := range tos {
+			if si, ok := code[from]; !ok || to != si.end() {
+				endsBlock[from] = true
+				leaders[to] = true
+			}
+		}
+	}
+	for address := range endsBlock {
+		if si, ok := code[address]; ok {
+			leaders[si.end()] = true
+		}
+	}
+
+	// Collect the instructions of each block
+	g := &CFG{image: image}
+	starts := []int{}
+	for address := range leaders {
+		if _, ok := code[address]; ok {
+			starts = append(starts, address)
+		}
+	}
+	sort.Ints(starts)
+	for _, start := range starts {
+		block := &BasicBlock{Start: start, Dynamic: map[int]bool{}}
+		address := start
+		for {
+			si := code[address]
+			block.code = append(block.code, si)
+			address = si.end()
+			_, next := code[address]
+			if endsBlock[si.address] || leaders[address] || !next {
+				break
+			}
+		}
+		last := block.code[len(block.code)-1]
+		block.End = last.end()
+		block.Halts = last.inst.halts
+		succs, computed := successorsOf(last)
+		block.Computed = computed
+		block.Successors = append(block.Successors, succs...)
+		for _, to := range dynamic[last.address] {
+			if !containsInt(block.Successors, to) {
+				block.Successors = append(block.Successors, to)
+				block.Dynamic[to] = true
+			}
+		}
+		sort.Ints(block.Successors)
+		g.Blocks = append(g.Blocks, block)
+	}
+	return g
+}
+
+// overlay returns the image with the instructions the trace saw executed written over cells
+// holding no valid instruction, or the image itself if there are none
+func (t *Trace) overlay(image []int) []int {
+	addresses := []int{}
+	for address, cells := range t.code {
+		if _, ok := decodeAt(image, address); !ok && address+len(cells) <= len(image) {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return image
+	}
+	sort.Ints(addresses)
+	overlaid := append([]int(nil), image...)
+	for _, address := range addresses {
+		copy(overlaid[address:], t.code[address])
+	}
+	return overlaid
+}
+
+// containsInt reports whether a list of integers includes the passed value
+func containsInt(vals []int, val int) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// BlockAt returns the block holding the instruction at the passed address, or nil if there is
+// none
+func (g *CFG) BlockAt(address int) *BasicBlock {
+	for _, block := range g.Blocks {
+		if address >= block.Start && address < block.End {
+			return block
+		}
+	}
+	return nil
+}
+
+// blockStarting returns the block starting at the passed address, or nil if there is none
+func (g *CFG) blockStarting(address int) *BasicBlock {
+	i := sort.Search(len(g.Blocks), func(i int) bool { return g.Blocks[i].Start >= address })
+	if i < len(g.Blocks) && g.Blocks[i].Start == address {
+		return g.Blocks[i]
+	}
+	return nil
+}
+
+// Print writes the instructions of a block followed by its successors to w
+func (b *BasicBlock) Print(w io.Writer) {
+	fmt.Fprintf(w, "Block %d-%d (%d instructions)\n", b.Start, b.End-1, len(b.code))
+	for _, si := range b.code {
+		fmt.Fprintf(w, "\t%4d:\t%s\n", si.address, disassemble(si))
+	}
+	succs := []string{}
+	for _, succ := range b.Successors {
+		if b.Dynamic[succ] {
+			succs = append(succs, fmt.Sprintf("%d (traced)", succ))
+		} else {
+			succs = append(succs, fmt.Sprintf("%d", succ))
+		}
+	}
+	switch {
+	case b.Computed:
+		succs = append(succs, "computed")
+	case b.Halts:
+		succs = append(succs, "halt")
+	}
+	fmt.Fprintf(w, "Successors: %s\n", strings.Join(succs, ", "))
+}
+
+// WriteDOT writes the graph to w in Graphviz DOT format.  Node IDs are quoted so that those of
+// negative jump targets are valid.  Transfers known only from a trace are dashed and
+// successors that are not valid instructions are shown in red.
+func (g *CFG) WriteDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph cfg {")
+	fmt.Fprintln(w, "\tnode [shape=box, fontname=\"monospace\"];")
+	invalid := map[int]bool{}
+	for _, block := range g.Blocks {
+		label := ""
+		for _, si := range block.code {
+			label += fmt.Sprintf("%d: %s\\l", si.address, disassemble(si))
+		}
+		if block.Computed {
+			label += "(computed jump)\\l"
+		}
+		fmt.Fprintf(w, "\t\"b%d\" [label=\"%s\"];\n", block.Start, label)
+		for _, succ := range block.Successors {
+			if g.blockStarting(succ) == nil {
+				invalid[succ] = true
+			}
+			style := ""
+			if block.Dynamic[succ] {
+				style = " [style=dashed]"
+			}
+			fmt.Fprintf(w, "\t\"b%d\" -> \"b%d\"%s;\n", block.Start, succ, style)
+		}
+	}
+	addresses := []int{}
+	for address := range invalid {
+		addresses = append(addresses, address)
+	}
+	sort.Ints(addresses)
+	for _, address := range addresses {
+		fmt.Fprintf(w, "\t\"b%d\" [label=\"%d: not an instruction\", color=red];\n", address, address)
+	}
+	fmt.Fprintln(w, "}")
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlockAtIPAfterRun(t *testing.T) {
+	vm := loadString(t, "1102,3,33,0,99") // overwrites its first instruction with a halt
+	if err := vm.Run(false); err != nil {
+		t.Fatal(err)
+	}
+	if block := BuildCFG(vm.memory, nil, vm.IP()).BlockAt(vm.IP()); block == nil || block.Start != 4 {
+		t.Errorf("got block %+v at ip %d", block, vm.IP())
+	}
+}
+
+func TestDOTQuotesNegativeTargets(t *testing.T) {
+	registerDay5Opcodes(t)
+	vm := loadString(t, "1105,1,-1")
+	var b strings.Builder
+	BuildCFG(vm.memory, nil).WriteDOT(&b)
+	if !strings.Contains(b.String(), `"b0" -> "b-1"`) || strings.Contains(b.String(), "\tb-1") {
+		t.Errorf("got graph\n%s", b.String())
+	}
+}
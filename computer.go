@@ -6,7 +6,10 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -14,7 +17,51 @@ import (
 )
 
 // VM a virtual machine that can load and run Intcode
-type VM []int // VM's memory
+type VM struct {
+	memory      []int               // VM's memory
+	maxSteps    int                 // maximum number of instructions a run may execute, 0 for no limit
+	detectLoops bool                // whether runs should stop when they revisit an identical state
+	loops       *loopDetector       // states visited by the current run when detecting loops
+	profiler    *Profiler           // optional execution profiler, nil unless profiling is enabled
+	running     bool                // whether a program is currently running
+	ip          int                 // address of the instruction executing, or where the last run stopped
+	steps       int                 // instructions executed since the last run started, including resumed runs
+	inputs      []int               // values queued for the program to read
+	inputFunc   func() (int, error) // optional source of input when the queue is empty
+	outputs     []int               // values written by the program
+	trace       *Trace              // optional record of the control transfers made by runs
+	observers   []Observer          // notified of what the VM does, in the order attached
+	bus         *deviceBus          // devices mapped into the address space, nil if there are none
+	selfModify  SelfModifyMode      // how runs treat writes by the program to its own code
+	smc         *smcTracker         // code cells of the current run when tracking self-modification
+	smcLog      []SelfModification  // writes to code made during the last tracked run
+	engine      Engine              // how runs execute instructions
+	compliance  ComplianceMode      // how strictly runs follow the Intcode specification
+	threaded    *threadedCode       // instructions pre-decoded by the threaded engine
+}
+
+// ErrStepLimit is the cause reported when a run exceeds the VM's maximum step count
+var ErrStepLimit = errors.New("step limit reached")
+
+// cancelCheckInterval is how many instructions are executed between checks for cancellation
+const cancelCheckInterval = 1024
+
+// AbortError reports a run that was stopped before the program reached a halt instruction
+type AbortError struct {
+	IP    int   // instruction pointer at which execution stopped
+	Steps int   // number of instructions executed before stopping
+	Cause error // ErrStepLimit or the error of the context that was cancelled
+}
+
+// Error formats the abort for display
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("execution aborted at ip %d after %d steps: %v", e.IP, e.Steps, e.Cause)
+}
+
+// Unwrap exposes the cause of the abort to errors.Is and errors.As
+func (e *AbortError) Unwrap() error {
+	return e.Cause
+}
 
 // ParamMode is an enum that defines opcode parameter mode
 type ParamMode int
@@ -28,32 +75,337 @@ const (
 	ValueMode ParamMode = 9
 )
 
+// ComplianceMode selects how strictly the VM follows the Intcode specification, which never
+// allows parameters an instruction writes to be in immediate mode
+type ComplianceMode int
+
+const (
+	// ComplianceCompatible honors a write parameter in immediate mode by writing to the
+	// parameter's own cell, as the VM always has.  This is the default.
+	ComplianceCompatible ComplianceMode = iota
+	// ComplianceStrict stops the run with an error at an instruction with a write parameter
+	// in immediate mode
+	ComplianceStrict
+)
+
+// String returns the name of the mode
+func (m ComplianceMode) String() string {
+	switch m {
+	case ComplianceCompatible:
+		return "compatible"
+	case ComplianceStrict:
+		return "strict"
+	default:
+		return fmt.Sprintf("mode(%d)", int(m))
+	}
+}
+
+// maxParams is the largest number of parameters taken by any instruction
+const maxParams = 3
+
+// paramModes holds the mode of each parameter of a decoded instruction
+type paramModes [maxParams]ParamMode
+
+// jumpInfo describes how an instruction may transfer control somewhere other than the
+// instruction that follows it
+type jumpInfo struct {
+	target      int  // index of the parameter holding the destination
+	conditional bool // whether the instruction may instead continue with the next instruction
+}
+
+// instruction describes an opcode supported by the VM
+type instruction struct {
+	opcode   int                                        // value of the opcode
+	mnemonic string                                     // name used when displaying the instruction
+	width    int                                        // number of memory cells occupied by the opcode and its parameters
+	halts    bool                                       // whether executing the instruction stops the VM
+	jump     *jumpInfo                                  // how the instruction may jump, nil if it never does
+	writes   []int                                      // indexes of the parameters the instruction writes to
+	alu      func(a, b int) int                         // operation of instructions combining two inputs into an output
+	exec     func(vm *VM, ip int, modes paramModes) int // carries out the instruction returning the next address, nil when it halts
+	extended bool                                       // whether the instruction was added through RegisterOpcode
+	pure     bool                                       // whether a registered instruction may safely be executed again
+}
+
+// instructions lists the opcodes supported by the VM, built in and registered
+var instructions = map[int]instruction{
+	1: {
+		opcode:   1,
+		mnemonic: "ADD",
+		width:    4,
+		writes:   []int{2},
+		alu:      func(a, b int) int { return a + b },
+		exec: func(vm *VM, ip int, modes paramModes) int {
+			vm.add(ip+1, ip+2, ip+3, modes[0], modes[1], modes[2])
+			return ip + 4
+		},
+	},
+	2: {
+		opcode:   2,
+		mnemonic: "MUL",
+		width:    4,
+		writes:   []int{2},
+		alu:      func(a, b int) int { return a * b },
+		exec: func(vm *VM, ip int, modes paramModes) int {
+			vm.mul(ip+1, ip+2, ip+3, modes[0], modes[1], modes[2])
+			return ip + 4
+		},
+	},
+	99: {opcode: 99, mnemonic: "HLT", width: 1, halts: true},
+}
+
+// dispatch is a table indexed by opcode, built from instructions, so that decoding an
+// instruction needs no map lookup
+var dispatch [100]*instruction
+
+func init() {
+	for opcode := range instructions {
+		inst := instructions[opcode]
+		dispatch[opcode] = &inst
+	}
+}
+
+// decode splits an instruction value into the instruction for its opcode (the two rightmost
+// digits) and the modes of its parameters (the remaining digits read right to left).  It
+// reports false if the value is not a valid instruction; decodeError explains why.
+func decode(val int) (*instruction, paramModes, bool) {
+	var modes paramModes
+	if val < 0 {
+		return nil, modes, false
+	}
+	inst := dispatch[val%100]
+	if inst == nil {
+		return nil, modes, false
+	}
+	digits := val / 100
+	for param := 0; param < inst.width-1; param++ {
+		mode := ParamMode(digits % 10)
+		if mode != PositionMode && mode != ImmediateMode {
+			return nil, modes, false
+		}
+		modes[param] = mode
+		digits /= 10
+	}
+	return inst, modes, true
+}
+
+// checkCompliance returns an error if a decoded instruction breaks the specification in a way
+// the VM's compliance mode refuses
+func (vm *VM) checkCompliance(inst *instruction, modes paramModes, ip int) error {
+	if vm.compliance != ComplianceStrict {
+		return nil
+	}
+	for _, param := range inst.writes {
+		if modes[param] == ImmediateMode {
+			return fmt.Errorf("%s writes through parameter %d in immediate mode at position %v",
+				inst.mnemonic, param+1, ip)
+		}
+	}
+	return nil
+}
+
+// decodeError returns the reason decode rejected the passed value
+func decodeError(val int) error {
+	if val < 0 || dispatch[val%100] == nil {
+		return fmt.Errorf("Invalid opcode %v", val%100)
+	}
+	digits := val / 100
+	for param := 0; param < dispatch[val%100].width-1; param++ {
+		if mode := ParamMode(digits % 10); mode != PositionMode && mode != ImmediateMode {
+			return fmt.Errorf("Invalid mode %d for parameter %d of %v", mode, param+1, val)
+		}
+		digits /= 10
+	}
+	return nil
+}
+
 // Size returns the current size of the memory in the VM
-func (vm VM) Size() int {
-	return len(vm)
+func (vm *VM) Size() int {
+	return len(vm.memory)
+}
+
+// Clone returns a copy of the VM sharing nothing with the original.  Memory, I/O and settings
+// are copied; observers, including profilers and traces, devices, and the input fallback stay
+// with the original and pre-decoded code is rebuilt by the copy as it runs.
+func (vm *VM) Clone() *VM {
+	return &VM{
+		memory:      append([]int(nil), vm.memory...),
+		maxSteps:    vm.maxSteps,
+		detectLoops: vm.detectLoops,
+		ip:          vm.ip,
+		steps:       vm.steps,
+		inputs:      append([]int(nil), vm.inputs...),
+		outputs:     append([]int(nil), vm.outputs...),
+		selfModify:  vm.selfModify,
+		engine:      vm.engine,
+		compliance:  vm.compliance,
+	}
+}
+
+// SetCompliance selects how strictly subsequent runs follow the Intcode specification
+func (vm *VM) SetCompliance(mode ComplianceMode) {
+	vm.compliance = mode
+	vm.threaded = nil // code compiled under the previous mode skipped its checks
+}
+
+// Compliance returns how strictly runs follow the Intcode specification
+func (vm *VM) Compliance() ComplianceMode {
+	return vm.compliance
+}
+
+// IP returns the address of the instruction executing or, between runs, the address at which
+// the last run stopped
+func (vm *VM) IP() int {
+	return vm.ip
+}
+
+// SetIP moves the instruction pointer to an address in memory so that Resume continues from it
+func (vm *VM) SetIP(address int) error {
+	if vm.running {
+		return fmt.Errorf("cannot move the IP of a running VM")
+	}
+	if address < 0 || address >= len(vm.memory) {
+		return fmt.Errorf("address %d is outside memory of size %d", address, len(vm.memory))
+	}
+	vm.ip = address
+	return nil
+}
+
+// Peek returns the value held in a memory cell.  Unlike ModeRead it is meant for inspecting
+// memory from outside the program, so devices and observers do not see the access.
+func (vm *VM) Peek(address int) (int, error) {
+	if address < 0 || address >= len(vm.memory) {
+		return 0, fmt.Errorf("address %d is outside memory of size %d", address, len(vm.memory))
+	}
+	return vm.memory[address], nil
+}
+
+// Poke stores a value in a memory cell as Peek reads one, without devices or observers seeing
+// the access
+func (vm *VM) Poke(address, val int) error {
+	if vm.running {
+		return fmt.Errorf("cannot change the memory of a running VM")
+	}
+	if address < 0 || address >= len(vm.memory) {
+		return fmt.Errorf("address %d is outside memory of size %d", address, len(vm.memory))
+	}
+	if vm.threaded != nil {
+		vm.threaded.invalidate(address)
+	}
+	vm.memory[address] = val
+	return nil
+}
+
+// Steps returns the number of instructions executed since the last run started, including any
+// resumed runs since
+func (vm *VM) Steps() int {
+	return vm.steps
+}
+
+// SetMaxSteps limits the number of instructions a single run may execute before it is aborted
+// with an AbortError.  A limit of zero or less removes the limit.
+func (vm *VM) SetMaxSteps(steps int) {
+	if steps < 0 {
+		steps = 0
+	}
+	vm.maxSteps = steps
+}
+
+// MaxSteps returns the VM's step limit, zero meaning there is no limit
+func (vm *VM) MaxSteps() int {
+	return vm.maxSteps
+}
+
+// EnableProfiler attaches a fresh profiler to the VM and returns it so that subsequent runs
+// record execution counts and memory usage
+func (vm *VM) EnableProfiler() *Profiler {
+	vm.DisableProfiler()
+	vm.profiler = newProfiler()
+	vm.AddObserver(vm.profiler)
+	return vm.profiler
+}
+
+// DisableProfiler detaches any profiler from the VM
+func (vm *VM) DisableProfiler() {
+	if vm.profiler != nil {
+		vm.RemoveObserver(vm.profiler)
+		vm.profiler = nil
+	}
+}
+
+// Profiler returns the profiler attached to the VM or nil if profiling is not enabled
+func (vm *VM) Profiler() *Profiler {
+	return vm.profiler
+}
+
+// runFault carries an invalid memory access, or an error raised by an instruction, out of a
+// running program so that the run can stop with an error rather than ending the process
+type runFault struct {
+	err error
+}
+
+// fault reports an invalid memory access.  While a program runs the run is stopped with an
+// error; otherwise the access is fatal.
+func (vm *VM) fault(format string, args ...interface{}) {
+	err := fmt.Errorf(format, args...)
+	if vm.running {
+		panic(runFault{err})
+	}
+	log.Fatal(err)
+}
+
+// parameter returns the address held in the memory cell of a position mode parameter, which
+// is missing when a truncated program's last instruction runs past the end of memory
+func (vm *VM) parameter(address int) int {
+	if address < 0 || address >= vm.Size() {
+		vm.fault("attempt to read a parameter at address %d but memory stops at %d", address, vm.Size())
+	}
+	return vm.memory[address]
 }
 
 // immediateWrite attempts to write a value to the VM's memory using the 'immediate' mode
 // where the passed address is the address of the desired data
-func (vm VM) immediateWrite(address, val int) {
+func (vm *VM) immediateWrite(address, val int) {
+	if vm.bus != nil {
+		if d, offset, ok := vm.bus.lookup(address); ok {
+			for _, o := range vm.observers {
+				o.OnWrite(vm, address, 0, val)
+			}
+			d.Write(offset, val)
+			return
+		}
+	}
 	if address < 0 {
-		log.Fatalf("attempt to write to a negative address of %d", address)
+		vm.fault("attempt to write to a negative address of %d", address)
+	}
+	if address >= vm.Size() {
+		vm.fault("attempt to write to address %d but memory stops at %d", address, vm.Size())
+	}
+	if vm.smc != nil {
+		vm.checkWrite(address, val)
+	}
+	for _, o := range vm.observers {
+		o.OnWrite(vm, address, vm.memory[address], val)
 	}
-	if address > vm.Size() {
-		log.Fatalf("attempt to write to address %d but memory stops at %d", address, vm.Size())
+	if vm.loops != nil {
+		vm.loops.recordWrite(address, vm.memory[address], val)
 	}
-	vm[address] = val
+	if vm.threaded != nil {
+		vm.threaded.invalidate(address)
+	}
+	vm.memory[address] = val
 }
 
 // positionWrite attempts to write a value to the VM's memory using the 'position' mode
 // where the location pointed to by the passed address contains the address of the desired
 // data
-func (vm VM) positionWrite(address, val int) {
-	vm.immediateWrite(vm[address], val)
+func (vm *VM) positionWrite(address, val int) {
+	vm.immediateWrite(vm.parameter(address), val)
 }
 
-// ModeWrite writes to memory using the mode passed
-func (vm VM) ModeWrite(address, val int, mode ParamMode) {
+// ModeWrite writes to memory using the mode passed.  In immediate mode the value is written to
+// the passed address itself; whether programs may do this is up to the VM's compliance mode.
+func (vm *VM) ModeWrite(address, val int, mode ParamMode) {
 	if mode == ImmediateMode {
 		vm.immediateWrite(address, val)
 	} else {
@@ -63,33 +415,46 @@ func (vm VM) ModeWrite(address, val int, mode ParamMode) {
 
 // immediateRead attempts to retreive a value from VM's memory using the 'immediate' mode
 // where the passed address is the address of the desired data
-func (vm VM) immediateRead(address int) int {
+func (vm *VM) immediateRead(address int) int {
+	if vm.bus != nil {
+		if d, offset, ok := vm.bus.lookup(address); ok {
+			val := d.Read(offset)
+			for _, o := range vm.observers {
+				o.OnRead(vm, address, val)
+			}
+			return val
+		}
+	}
 	if address < 0 {
-		log.Fatalf("attempt to read to a negative address of %d", address)
+		vm.fault("attempt to read to a negative address of %d", address)
 	}
-	if address > vm.Size() {
-		log.Fatalf("attempt to read from address %d but memory stops at %d", address, vm.Size())
+	if address >= vm.Size() {
+		vm.fault("attempt to read from address %d but memory stops at %d", address, vm.Size())
 	}
-	return vm[address]
+	for _, o := range vm.observers {
+		o.OnRead(vm, address, vm.memory[address])
+	}
+	return vm.memory[address]
 }
 
 // positionRead attempts to retreive a value from VM's memory using the 'position' mode
 // where the location pointed to by the passed address contains the address of the desired
 // data.
-func (vm VM) positionRead(address int) int {
-	return vm.immediateRead(vm[address])
+func (vm *VM) positionRead(address int) int {
+	return vm.immediateRead(vm.parameter(address))
 }
 
 // ModeRead reads from memory using the mode passed
-func (vm VM) ModeRead(address int, mode ParamMode) int {
+func (vm *VM) ModeRead(address int, mode ParamMode) int {
 	if mode == ImmediateMode {
 		return vm.immediateRead(address)
 	}
 	return vm.positionRead(address)
 }
 
-// Load attempts to load VM's memory with Intcode from a file
-func (vm VM) Load(fileName string) (VM, error) {
+// Load attempts to load VM's memory with Intcode from a file replacing any program previously
+// loaded
+func (vm *VM) Load(fileName string) (*VM, error) {
 
 	// Open data file containing a program
 	file, err := os.Open(fileName)
@@ -98,107 +463,215 @@ func (vm VM) Load(fileName string) (VM, error) {
 	}
 	defer file.Close()
 
-	// Read data file containing the program and load it into core memory
-	scanner := bufio.NewScanner(file)
+	return vm.LoadFrom(file)
+}
+
+// LoadFrom attempts to load VM's memory with comma separated Intcode read from r replacing
+// any program previously loaded
+func (vm *VM) LoadFrom(r io.Reader) (*VM, error) {
+
+	// Read the program and load it into core memory
+	scanner := bufio.NewScanner(r)
 	scanner.Split(scanCommas)
+	memory := []int{}
 	address := 0
 	for scanner.Scan() {
-		val, err := strconv.Atoi(scanner.Text())
+		val, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
 		if err != nil {
-			return nil, fmt.Errorf("at address %d: %v", address, val)
+			return nil, fmt.Errorf("at address %d: %v", address, err)
 		}
-		vm = append(vm, val)
+		memory = append(memory, val)
 		address++
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
+	vm.memory = memory
+	vm.ip = 0
+	vm.steps = 0
+	vm.threaded = nil
 	return vm, nil
 }
 
 // add implments the 'add' opcode for the VM
-func (vm VM) add(termAddress1, termAddress2, resultAddress int, mode1, mode2, mode3 ParamMode) {
+func (vm *VM) add(termAddress1, termAddress2, resultAddress int, mode1, mode2, mode3 ParamMode) {
 	vm.ModeWrite(resultAddress, vm.ModeRead(termAddress1, mode1)+vm.ModeRead(termAddress2, mode2), mode3)
 }
 
 // mul implements the 'mul' opcode for the VM
-func (vm VM) mul(termAddress1, termAddress2, resultAddress int, mode1, mode2, mode3 ParamMode) {
+func (vm *VM) mul(termAddress1, termAddress2, resultAddress int, mode1, mode2, mode3 ParamMode) {
 	vm.ModeWrite(resultAddress, vm.ModeRead(termAddress1, mode1)*vm.ModeRead(termAddress2, mode2), mode3)
 }
 
+// peek returns the value a parameter would read using the passed mode, without recording the
+// access or failing on bad addresses, for display purposes
+func (vm *VM) peek(address int, mode ParamMode) string {
+	if address >= 0 && address < vm.Size() && mode == PositionMode {
+		address = vm.memory[address]
+	}
+	if vm.bus != nil {
+		if _, _, ok := vm.bus.lookup(address); ok {
+			return "dev"
+		}
+	}
+	if address < 0 || address >= vm.Size() {
+		return "?"
+	}
+	return strconv.Itoa(vm.memory[address])
+}
+
 // fmtParam formats a parameter for verbose display depending upon the mode
-func (vm VM) fmtParam(val int, mode ParamMode) string {
+func (vm *VM) fmtParam(val int, mode ParamMode) string {
 	switch mode {
 	case ImmediateMode:
-		return fmt.Sprintf("$%4d  (%d)", val, vm.ModeRead(val, mode))
+		return fmt.Sprintf("$%4d  (%s)", val, vm.peek(val, mode))
 	case PositionMode:
-		return fmt.Sprintf("[%4d] (%d)", val, vm.ModeRead(val, mode))
+		return fmt.Sprintf("[%4d] (%s)", val, vm.peek(val, mode))
 	case ValueMode:
-		return fmt.Sprintf(" %4d  (%d)", val, vm.ModeRead(val, mode))
+		return fmt.Sprintf(" %4d  (%s)", val, vm.peek(val, mode))
 	default:
 		return "Err"
 	}
 }
 
-func (vm VM) getMode(directive string, position int) ParamMode {
-	switch directive[position] {
-	case ' ':
-		fallthrough
-	case '0':
-		return PositionMode
-	case '1':
-		return ImmediateMode
-	default:
-		log.Fatalf("Encountered invalid directive '%v'", directive[position])
+// interpret decodes and executes the instruction at the passed address returning the address
+// of the next instruction or whether the VM halted
+func (vm *VM) interpret(ip int) (int, bool, error) {
+	inst, modes, ok := decode(vm.memory[ip])
+	if !ok {
+		return ip, false, fmt.Errorf("%v encountered at position %v", decodeError(vm.memory[ip]), ip)
+	}
+	if err := vm.checkCompliance(inst, modes, ip); err != nil {
+		return ip, false, err
+	}
+	if vm.smc != nil {
+		vm.smc.markExecuted(ip, inst.width)
 	}
-	return ValueMode
+	if len(vm.observers) > 0 {
+		vm.observeStep(ip, inst, modes)
+	}
+	if inst.halts {
+		return ip, true, nil
+	}
+	return inst.exec(vm, ip, modes), false, nil
 }
 
-// Run attempts to execute the loaded Intcode program in the VM
-func (vm VM) Run(verbose bool) error {
+// Run attempts to execute the loaded Intcode program in the VM, printing each instruction as
+// it is executed if verbose is set
+func (vm *VM) Run(verbose bool) error {
+	return vm.RunContext(context.Background(), verbose)
+}
+
+// RunContext attempts to execute the loaded Intcode program in the VM until it halts, the
+// VM's step limit is exceeded, or the passed context is done.  The latter two stop the run
+// with an AbortError.  Observers attached to the VM are notified as the program runs.
+func (vm *VM) RunContext(ctx context.Context, verbose bool) error {
+	vm.steps = 0
+	return vm.run(ctx, 0, verbose)
+}
+
+// Resume continues executing the program from where the last run stopped
+func (vm *VM) Resume(verbose bool) error {
+	return vm.ResumeContext(context.Background(), verbose)
+}
+
+// ResumeContext continues executing the program from where the last run stopped, e.g. after
+// an AbortError or restoring a snapshot, as RunContext does from the start.  The step limit
+// applies afresh to the resumed run.  A VM stopped at a halt instruction has finished, so
+// resuming it executes nothing.
+func (vm *VM) ResumeContext(ctx context.Context, verbose bool) error {
+	if vm.Size() > 0 {
+		if vm.ip < 0 || vm.ip >= vm.Size() {
+			return fmt.Errorf("cannot resume at %d outside memory", vm.ip)
+		}
+		if inst, _, ok := decode(vm.memory[vm.ip]); ok && inst.halts {
+			return nil
+		}
+	}
+	return vm.run(ctx, vm.ip, verbose)
+}
+
+// run executes the program from the passed address
+func (vm *VM) run(ctx context.Context, start int, verbose bool) (err error) {
 	if vm.Size() == 0 {
 		return fmt.Errorf("no program loaded")
 	}
-	ip := 0 // instruction pointer
-execLoop:
-	for {
+	ip := start // instruction pointer
+	steps := 0  // number of instructions executed
 
-		directive := fmt.Sprintf("%5d", vm.immediateRead(ip))
-		mode1 := vm.getMode(directive, 0)
-		mode2 := vm.getMode(directive, 1)
-		mode3 := vm.getMode(directive, 2)
-		opcode, err := strconv.Atoi(strings.TrimLeft(directive[3:], " "))
-		if err != nil {
-			log.Fatal(err)
+	// Invalid memory accesses by the program end the run with an error
+	vm.running = true
+	defer func() {
+		vm.running = false
+		vm.ip = ip
+		vm.steps += steps
+		if r := recover(); r != nil {
+			fault, ok := r.(runFault)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%w at position %v", fault.err, ip)
+		}
+		for _, o := range vm.observers {
+			o.OnHalt(vm, err)
 		}
+	}()
+	if verbose {
+		printer := NewStepPrinter(os.Stdout)
+		vm.AddObserver(printer)
+		defer vm.RemoveObserver(printer)
+	}
+	if vm.detectLoops && vm.bus == nil {
+		vm.loops = newLoopDetector(vm.memory)
+		defer func() { vm.loops = nil }()
+	}
+	if vm.selfModify != SelfModifyIgnore {
+		vm.smc = newSMCTracker(vm.memory)
+		defer func() {
+			vm.smcLog = vm.smc.log
+			vm.smc = nil
+		}()
+	}
+	execute := (*VM).interpret
+	if vm.engine == ThreadedEngine {
+		if vm.threaded == nil || vm.threaded.generation != opcodeGeneration {
+			vm.threaded = newThreadedCode(vm.Size())
+		}
+		execute = (*VM).executeThreaded
+	}
+	for {
 
-		switch opcode {
-		case 1: // addition
-			if verbose {
-				p1 := vm.fmtParam(ip+1, mode1)
-				p2 := vm.fmtParam(ip+2, mode2)
-				p3 := vm.fmtParam(ip+3, mode3)
-				fmt.Printf("%4d:\tADD\t%s\t%s\t%s\n", ip, p1, p2, p3)
-			}
-			vm.add(ip+1, ip+2, ip+3, mode1, mode2, mode3)
-			ip = ip + 4
-		case 2: // multiplication
-			if verbose {
-				p1 := vm.fmtParam(ip+1, mode1)
-				p2 := vm.fmtParam(ip+2, mode2)
-				p3 := vm.fmtParam(ip+3, mode3)
-				fmt.Printf("%4d:\tMUL\t%s\t%s\t%s\n", ip, p1, p2, p3)
+		if vm.maxSteps > 0 && steps >= vm.maxSteps {
+			return &AbortError{IP: ip, Steps: steps, Cause: ErrStepLimit}
+		}
+		if steps%cancelCheckInterval == 0 {
+			select {
+			case <-ctx.Done():
+				return &AbortError{IP: ip, Steps: steps, Cause: ctx.Err()}
+			default:
 			}
-			vm.mul(ip+1, ip+2, ip+3, mode1, mode2, mode3)
-			ip = ip + 4
-		case 99: // halt
-			if verbose {
-				fmt.Printf("%4d:\tHLT\n", ip)
+		}
+		if vm.loops != nil {
+			if err := vm.loops.visit(ip, steps, vm.memory); err != nil {
+				return err
 			}
-			ip = ip + 1
-			break execLoop
-		default:
-			return fmt.Errorf("Invalid opcode %v encountered at position %v", opcode, ip)
 		}
-		if ip > vm.Size() {
+		steps++
+
+		next, halted, err := execute(vm, ip)
+		if err != nil {
+			return err
+		}
+		if halted {
+			break
+		}
+		ip = next
+
+		if ip < 0 {
+			return fmt.Errorf("jump to address %v outside memory", ip)
+		}
+		if ip >= vm.Size() {
 			return fmt.Errorf("no halt instruction occured before end of memory")
 		}
 	}
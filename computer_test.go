@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// loadString returns a VM loaded with a comma separated program
+func loadString(t *testing.T, program string) *VM {
+	t.Helper()
+	vm, err := new(VM).LoadFrom(strings.NewReader(program))
+	if err != nil {
+		t.Fatalf("loading %q: %v", program, err)
+	}
+	return vm
+}
+
+func TestTruncatedImageFaults(t *testing.T) {
+	programs := []string{
+		"1,0,0",     // result parameter missing
+		"1,0",       // second input and result parameters missing
+		"1",         // all parameters missing
+		"2,0,0,0,1", // second instruction truncated
+		"1101,1,1",  // immediate inputs, result parameter missing
+	}
+	for _, engine := range []Engine{InterpreterEngine, ThreadedEngine} {
+		for _, program := range programs {
+			vm := loadString(t, program)
+			vm.SetEngine(engine)
+			err := vm.Run(false)
+			if err == nil || !strings.Contains(err.Error(), "memory stops") {
+				t.Errorf("%v running %q: got error %v, want a fault past the end of memory", engine, program, err)
+			}
+		}
+	}
+}
+
+func TestComplianceModes(t *testing.T) {
+	// The ADD's third parameter is in immediate mode so it writes to its own cell
+	const program = "11101,1,1,3,99"
+	for _, engine := range []Engine{InterpreterEngine, ThreadedEngine} {
+		vm := loadString(t, program)
+		vm.SetEngine(engine)
+		vm.SetCompliance(ComplianceStrict)
+		if err := vm.Run(false); err == nil || !strings.Contains(err.Error(), "immediate mode") {
+			t.Errorf("%v running %q strictly: got error %v", engine, program, err)
+		}
+		vm = loadString(t, program)
+		vm.SetEngine(engine)
+		vm.SetCompliance(ComplianceCompatible)
+		if err := vm.Run(false); err != nil || vm.memory[3] != 2 {
+			t.Errorf("%v running %q compatibly: memory %v, error %v", engine, program, vm.memory, err)
+		}
+	}
+}
+
+func TestComplianceChangeDiscardsThreadedCode(t *testing.T) {
+	vm := loadString(t, "11101,1,1,3,99")
+	vm.SetEngine(ThreadedEngine)
+	if err := vm.Run(false); err != nil {
+		t.Fatal(err)
+	}
+	vm.SetCompliance(ComplianceStrict)
+	if vm.threaded != nil {
+		t.Error("code compiled in compatible mode was kept")
+	}
+	vm.memory[3] = 3 // put back the cell the run wrote without touching the compiled code
+	if err := vm.Run(false); err == nil {
+		t.Error("got no error running strictly after compiling in compatible mode")
+	}
+}
+
+func TestResumeHaltedDoesNothing(t *testing.T) {
+	vm := loadString(t, "1,0,0,0,99")
+	if err := vm.Run(false); err != nil {
+		t.Fatal(err)
+	}
+	var snapshot bytes.Buffer
+	if err := vm.WriteSnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := new(VM).LoadSnapshotFrom(&snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, resumed := range []*VM{vm, restored} {
+		if err := resumed.Resume(false); err != nil || resumed.Steps() != 2 || resumed.IP() != 4 {
+			t.Errorf("resuming a halted VM: got ip %d after %d steps, error %v", resumed.IP(), resumed.Steps(), err)
+		}
+	}
+}
+
+// registerDay5Opcodes adds the input, output, jump, and comparison instructions of day 5, which
+// the VM leaves to RegisterOpcode, for the duration of a test
+func registerDay5Opcodes(t *testing.T) {
+	t.Helper()
+	flag := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	opcodes := []Opcode{
+		{Number: 3, Mnemonic: "IN", Params: 1, Writes: []int{0}, Exec: func(vm *VM, a *Args) error {
+			val, err := a.Input()
+			if err == nil {
+				a.Write(0, val)
+			}
+			return err
+		}},
+		{Number: 4, Mnemonic: "OUT", Params: 1, Exec: func(vm *VM, a *Args) error {
+			a.Output(a.Read(0))
+			return nil
+		}},
+		{Number: 5, Mnemonic: "JNZ", Params: 2, Jump: &Jump{Param: 1, Conditional: true}, Pure: true, Exec: func(vm *VM, a *Args) error {
+			if a.Read(0) != 0 {
+				a.Jump(a.Read(1))
+			}
+			return nil
+		}},
+		{Number: 6, Mnemonic: "JZ", Params: 2, Jump: &Jump{Param: 1, Conditional: true}, Pure: true, Exec: func(vm *VM, a *Args) error {
+			if a.Read(0) == 0 {
+				a.Jump(a.Read(1))
+			}
+			return nil
+		}},
+		{Number: 7, Mnemonic: "LT", Params: 3, Writes: []int{2}, Pure: true, Exec: func(vm *VM, a *Args) error {
+			a.Write(2, flag(a.Read(0) < a.Read(1)))
+			return nil
+		}},
+		{Number: 8, Mnemonic: "EQ", Params: 3, Writes: []int{2}, Pure: true, Exec: func(vm *VM, a *Args) error {
+			a.Write(2, flag(a.Read(0) == a.Read(1)))
+			return nil
+		}},
+	}
+	for _, op := range opcodes {
+		if err := RegisterOpcode(op); err != nil {
+			t.Fatal(err)
+		}
+		number := op.Number
+		t.Cleanup(func() { UnregisterOpcode(number) })
+	}
+}
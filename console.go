@@ -0,0 +1,844 @@
+/*
+ * Intcode console commands
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// console is the state of the interactive Intcode console
+type console struct {
+	sessions consoleSessions
+	session  *consoleSession // session commands apply to, guarded by mutex for interrupt
+	timeout  time.Duration   // time limit of each run
+	quit     bool            // whether the user asked to leave
+	mutex    sync.Mutex
+}
+
+// consoleCommand is a command the console accepts
+type consoleCommand struct {
+	name    string   // full name of the command
+	aliases []string // other names accepted for the command
+	args    string   // synopsis of the arguments
+	help    string   // one-line description
+	minArgs int      // number of arguments required
+	maxArgs int      // number of arguments allowed, or -1 for any number
+	loaded  bool     // whether the command needs a program to be loaded
+	running bool     // whether the command may be used while the session's program runs
+	run     func(c *console, args []string) error
+}
+
+// usage returns the command's name with the synopsis of its arguments
+func (cmd *consoleCommand) usage() string {
+	return strings.TrimSpace(cmd.name + " " + cmd.args)
+}
+
+// usageError reports a command given the wrong number of arguments
+type usageError struct {
+	command *consoleCommand
+}
+
+// Error formats the error for display
+func (e *usageError) Error() string {
+	return fmt.Sprintf("usage: %s", e.command.usage())
+}
+
+// argError reports a command argument that could not be parsed
+type argError struct {
+	index int    // position of the argument, from zero
+	value string // argument as typed
+	want  string // description of the values accepted
+}
+
+// Error formats the error for display
+func (e *argError) Error() string {
+	return fmt.Sprintf("argument %d ('%s') must be %s", e.index+1, e.value, e.want)
+}
+
+// errNotLoaded is reported by commands needing a program when none has been loaded
+var errNotLoaded = errors.New("please load the VM first using 'LOAD <file name>'")
+
+// errRunning is reported by commands needing the VM while its program runs in the background
+var errRunning = errors.New("the program is running--pause it using Ctrl-C or wait for it using 'WAIT'")
+
+// intArg parses the argument at the passed index as an integer
+func intArg(args []string, index int) (int, error) {
+	val, err := strconv.Atoi(args[index])
+	if err != nil {
+		return 0, &argError{index, args[index], "an integer"}
+	}
+	return val, nil
+}
+
+// positiveArg parses the argument at the passed index as an integer greater than zero
+func positiveArg(args []string, index int) (int, error) {
+	val, err := strconv.Atoi(args[index])
+	if err != nil || val <= 0 {
+		return 0, &argError{index, args[index], "a positive integer"}
+	}
+	return val, nil
+}
+
+// choiceArg returns the argument at the passed index in upper case if it is one of the choices
+func choiceArg(args []string, index int, choices ...string) (string, error) {
+	arg := strings.ToUpper(args[index])
+	for _, choice := range choices {
+		if arg == choice {
+			return choice, nil
+		}
+	}
+	if len(choices) == 1 {
+		return "", &argError{index, args[index], choices[0]}
+	}
+	return "", &argError{index, args[index], "one of " + strings.Join(choices, ", ")}
+}
+
+// addressArg parses the argument at the passed index as an address in memory that is not
+// mapped to a device
+func (c *console) addressArg(args []string, index int) (int, error) {
+	address, err := strconv.Atoi(args[index])
+	if err != nil {
+		return 0, &argError{index, args[index], "an address"}
+	}
+	if address < 0 || address >= c.vm().Size() {
+		return 0, &argError{index, args[index], fmt.Sprintf("an address below %d", c.vm().Size())}
+	}
+	if c.vm().bus != nil {
+		if _, _, ok := c.vm().bus.lookup(address); ok {
+			return 0, &argError{index, args[index], "an address not mapped to a device"}
+		}
+	}
+	return address, nil
+}
+
+// consoleCommands are the console's commands in the order HELP lists them
+var consoleCommands []*consoleCommand
+
+func init() {
+	consoleCommands = []*consoleCommand{
+		{name: "LOAD", args: "<file name> [<patch file name>]", help: "load a program, applying a patch file", minArgs: 1, maxArgs: 2, run: (*console).load},
+		{name: "WRITE", aliases: []string{"POKE"}, args: "<address> <value>", help: "store a value in memory", minArgs: 2, maxArgs: 2, loaded: true, run: (*console).write},
+		{name: "PATCH", args: "[<address> <instruction> | SAVE <file name> | CLEAR]", help: "assemble an instruction in place, or list, save, or forget patches", maxArgs: -1, loaded: true, run: (*console).patch},
+		{name: "FILL", args: "<start> <end> <value>", help: "store a value in a range of addresses", minArgs: 3, maxArgs: 3, loaded: true, run: (*console).fill},
+		{name: "READ", aliases: []string{"PEEK"}, args: "<address>", help: "show the value at an address", minArgs: 1, maxArgs: 1, loaded: true, run: (*console).read},
+		{name: "RUN", args: "[RESUME]", help: "run the program from the start, or continue from the IP, in the background", maxArgs: 1, loaded: true, run: (*console).runProgram},
+		{name: "WAIT", help: "wait for the program running in the background to stop", running: true, run: (*console).wait},
+		{name: "STATUS", help: "show whether the program is running, paused, or halted", running: true, run: (*console).status},
+		{name: "RESET", help: "restore the program as it was loaded", run: (*console).reset},
+		{name: "RELOAD", help: "reread the program and its patch file", run: (*console).reload},
+		{name: "SETIP", aliases: []string{"JUMP"}, args: "[<address>]", help: "show or move the IP", maxArgs: 1, run: (*console).setIP},
+		{name: "LIMIT", args: "[<steps>]", help: "show or set the step limit of runs", maxArgs: 1, run: (*console).limit},
+		{name: "TIMEOUT", args: "[<seconds>]", help: "show or set the time limit of runs", maxArgs: 1, running: true, run: (*console).setTimeout},
+		{name: "DETECT", args: "[ON|OFF]", help: "show or set infinite loop detection", maxArgs: 1, run: (*console).detect},
+		{name: "ENGINE", args: "[INTERPRETER|THREADED]", help: "show or select how instructions are executed", maxArgs: 1, run: (*console).engine},
+		{name: "TRACE", args: "[ON|OFF]", help: "show or set tracing of control transfers", maxArgs: 1, run: (*console).trace},
+		{name: "CFG", args: "[<file name>]", help: "write the control-flow graph in DOT format", maxArgs: 1, loaded: true, run: (*console).cfg},
+		{name: "BLOCK", help: "show the basic block at the IP", loaded: true, run: (*console).block},
+		{name: "PROFILE", aliases: []string{"PROF"}, args: "[ON|OFF]", help: "report the profile, or turn profiling on or off", maxArgs: 1, run: (*console).profile},
+		{name: "SMC", args: "[OFF|REPORT|STRICT]", help: "report self-modifications, or set their detection", maxArgs: 1, run: (*console).smc},
+		{name: "COMPLIANCE", args: "[STRICT|COMPATIBLE]", help: "show or set spec compliance", maxArgs: 1, run: (*console).compliance},
+		{name: "MAP", args: "[CONSOLE|CLOCK|RANDOM <address> | FRAME <address> <width> <height>]", help: "list devices or map one into memory", maxArgs: 4, run: (*console).mapDevice},
+		{name: "UNMAP", args: "<address>", help: "remove the device mapped at an address", minArgs: 1, maxArgs: 1, run: (*console).unmap},
+		{name: "SCREEN", help: "show the mapped framebuffers", run: (*console).screen},
+		{name: "SNAPSHOT", aliases: []string{"SNAP"}, args: "SAVE|LOAD <file name>", help: "save or restore the VM state", minArgs: 2, maxArgs: 2, run: (*console).snapshot},
+		{name: "INPUT", aliases: []string{"IN"}, args: "[<value>...]", help: "queue input, or show the queue", maxArgs: -1, running: true, run: (*console).input},
+		{name: "OUTPUTS", aliases: []string{"OUT"}, help: "show the program's outputs as numbers and text", run: (*console).outputs},
+		{name: "CLEAROUT", help: "discard the program's outputs", run: (*console).clearOutputs},
+		{name: "NEW", args: "<name> [<file name>]", help: "start a session, optionally loading a program", minArgs: 1, maxArgs: 2, running: true, run: (*console).newSession},
+		{name: "USE", args: "<name>", help: "switch to another session", minArgs: 1, maxArgs: 1, running: true, run: (*console).useSession},
+		{name: "LIST", aliases: []string{"SESSIONS"}, help: "list the sessions", running: true, run: (*console).listSessions},
+		{name: "CLOSE", args: "<name>", help: "discard a session", minArgs: 1, maxArgs: 1, running: true, run: (*console).closeSession},
+		{name: "DIFF", args: "[<name>] <name>", help: "compare the memory of two sessions", minArgs: 1, maxArgs: 2, running: true, run: (*console).diff},
+		{name: "HELP", aliases: []string{"?"}, args: "[<command>]", help: "list the commands, or describe one", maxArgs: 1, running: true, run: (*console).help},
+		{name: "QUIT", aliases: []string{"EXIT"}, help: "leave the console, stopping any programs running", running: true, run: (*console).exit},
+	}
+}
+
+// lookupCommand returns the command with the passed name or alias, ignoring case
+func lookupCommand(name string) *consoleCommand {
+	name = strings.ToUpper(name)
+	for _, cmd := range consoleCommands {
+		if cmd.name == name {
+			return cmd
+		}
+		for _, alias := range cmd.aliases {
+			if alias == name {
+				return cmd
+			}
+		}
+	}
+	return nil
+}
+
+// newConsole returns a console with a single empty session
+func newConsole() *console {
+	session := newConsoleSession(defaultSession)
+	return &console{
+		sessions: consoleSessions{session.name: session},
+		session:  session,
+		timeout:  consoleTimeout,
+	}
+}
+
+// vm returns the VM of the session in use
+func (c *console) vm() *VM {
+	return c.session.vm
+}
+
+// execute runs a line typed at the console
+func (c *console) execute(line string) {
+	tokens := strings.Fields(line)
+	if len(tokens) == 0 {
+		return
+	}
+	cmd := lookupCommand(tokens[0])
+	if cmd == nil {
+		fmt.Printf("Unrecognized command '%s'--try 'HELP'\n", tokens[0])
+		return
+	}
+	args := tokens[1:]
+	var err error
+	switch {
+	case len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs):
+		err = &usageError{cmd}
+	case !cmd.running && c.session.busy():
+		err = errRunning
+	case cmd.loaded && c.vm().Size() == 0:
+		err = errNotLoaded
+	default:
+		err = cmd.run(c, args)
+	}
+
+	var usage *usageError
+	var badArg *argError
+	switch {
+	case err == nil:
+	case errors.As(err, &usage):
+		fmt.Printf("Usage: %s\n", cmd.usage())
+	case errors.As(err, &badArg):
+		fmt.Printf("Error: %s %v\n", cmd.name, err)
+		fmt.Printf("Usage: %s\n", cmd.usage())
+	default:
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+// load loads a program, and optionally a patch file, into the session
+func (c *console) load(args []string) error {
+	patchFile := ""
+	if len(args) > 1 {
+		patchFile = args[1]
+	}
+	if err := c.session.load(args[0], patchFile, false); err != nil {
+		return err
+	}
+	fmt.Printf("%s loaded\n", args[0])
+	if patchFile != "" {
+		fmt.Printf("%d patches applied from %s\n", len(c.session.patches), patchFile)
+	}
+	return nil
+}
+
+// write stores a value at an address
+func (c *console) write(args []string) error {
+	address, err := c.addressArg(args, 0)
+	if err != nil {
+		return err
+	}
+	val, err := intArg(args, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.vm().Poke(address, val); err != nil {
+		return err
+	}
+	fmt.Printf("%d written to %d\n", val, address)
+	return nil
+}
+
+// patch assembles an instruction at an address, or lists, saves, or forgets the patches made
+func (c *console) patch(args []string) error {
+	if len(args) == 0 {
+		if len(c.session.patches) == 0 {
+			fmt.Println("No patches made since loading")
+		}
+		for _, p := range c.session.patches {
+			fmt.Println(p.text)
+		}
+		return nil
+	}
+	if _, err := strconv.Atoi(args[0]); err != nil {
+		subcommand, err := choiceArg(args, 0, "SAVE", "CLEAR")
+		if err != nil {
+			return err
+		}
+		if subcommand == "CLEAR" {
+			if len(args) != 1 {
+				return &usageError{lookupCommand("PATCH")}
+			}
+			c.session.patches = c.session.patches[:0]
+			c.session.filePatches = 0
+			c.session.patchFile = ""
+			fmt.Println("Patch list cleared--memory is unchanged")
+			return nil
+		}
+		if len(args) != 2 {
+			return &usageError{lookupCommand("PATCH")}
+		}
+		if err := savePatches(args[1], c.session.patches); err != nil {
+			return err
+		}
+		fmt.Printf("%d patches written to %s\n", len(c.session.patches), args[1])
+		return nil
+	}
+	if len(args) < 2 {
+		return &usageError{lookupCommand("PATCH")}
+	}
+
+	p, err := parsePatch("PATCH "+strings.Join(args, " "), c.vm().Size())
+	if err != nil {
+		return err
+	}
+	if err := c.session.patch(p); err != nil {
+		return err
+	}
+	si, _ := decodeAt(c.vm().memory, p.address)
+	fmt.Printf("%d: %s (%v)\n", p.address, disassemble(si), p.values)
+	return nil
+}
+
+// fill stores a value in each address of an inclusive range
+func (c *console) fill(args []string) error {
+	for i := range args {
+		if _, err := intArg(args, i); err != nil {
+			return err
+		}
+	}
+	p, err := parsePatch("FILL "+strings.Join(args, " "), c.vm().Size())
+	if err != nil {
+		return err
+	}
+	if err := c.session.patch(p); err != nil {
+		return err
+	}
+	fmt.Printf("%d cells written from %d\n", len(p.values), p.address)
+	return nil
+}
+
+// read shows the value at an address
+func (c *console) read(args []string) error {
+	address, err := c.addressArg(args, 0)
+	if err != nil {
+		return err
+	}
+	val, err := c.vm().Peek(address)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d contains %d\n", address, val)
+	return nil
+}
+
+// runProgram runs the program from the start, or resumes it from the IP
+func (c *console) runProgram(args []string) error {
+	resume := false
+	if len(args) > 0 {
+		if _, err := choiceArg(args, 0, "RESUME"); err != nil {
+			return err
+		}
+		resume = true
+	}
+
+	// Programs own the terminal when it is mapped into memory, and commands read from files or
+	// pipes expect each to finish before the next
+	background := stdin.isTerminal()
+	for _, m := range c.vm().Devices() {
+		if _, ok := m.Device.(*CharDevice); ok {
+			background = false
+		}
+	}
+	if _, err := c.session.start(resume, background, c.timeout, stdin.notify); err != nil {
+		return err
+	}
+	if !background {
+		c.session.wait()
+		return nil
+	}
+	fmt.Printf("'%s' is running in the background--Ctrl-C pauses it and 'STATUS' reports on it\n", c.session.name)
+	return nil
+}
+
+// wait waits for the program running in the background to stop
+func (c *console) wait(args []string) error {
+	if !c.session.wait() {
+		fmt.Println(c.session.status())
+	}
+	return nil
+}
+
+// status shows what the program is doing
+func (c *console) status(args []string) error {
+	fmt.Println(c.session.status())
+	return nil
+}
+
+// interrupt pauses the program running in the session in use, if it is running
+func (c *console) interrupt() {
+	c.mutex.Lock()
+	session := c.session
+	c.mutex.Unlock()
+	session.pause()
+}
+
+// use switches to a session
+func (c *console) use(session *consoleSession) {
+	c.mutex.Lock()
+	c.session = session
+	c.mutex.Unlock()
+}
+
+// reset restores the program as it was loaded
+func (c *console) reset(args []string) error {
+	if err := c.session.reset(); err != nil {
+		return err
+	}
+	fmt.Printf("Reset to %s as loaded\n", c.session.file)
+	return nil
+}
+
+// reload rereads the program and its patch file
+func (c *console) reload(args []string) error {
+	if err := c.session.reload(); err != nil {
+		return err
+	}
+	fmt.Printf("%s reloaded\n", c.session.file)
+	return nil
+}
+
+// setIP shows or moves the IP
+func (c *console) setIP(args []string) error {
+	if len(args) == 0 {
+		fmt.Printf("IP is %d\n", c.vm().IP())
+		return nil
+	}
+	address, err := intArg(args, 0)
+	if err != nil {
+		return err
+	}
+	if err := c.vm().SetIP(address); err != nil {
+		return err
+	}
+	fmt.Printf("IP set to %d--continue from it using 'RUN RESUME'\n", c.vm().IP())
+	return nil
+}
+
+// limit shows or sets the step limit of runs
+func (c *console) limit(args []string) error {
+	if len(args) > 0 {
+		steps, err := intArg(args, 0)
+		if err != nil {
+			return err
+		}
+		c.vm().SetMaxSteps(steps)
+		fmt.Printf("Step limit set to %d\n", c.vm().MaxSteps())
+		return nil
+	}
+	fmt.Printf("Step limit is %d (0 means no limit)\n", c.vm().MaxSteps())
+	return nil
+}
+
+// setTimeout shows or sets the time limit of runs
+func (c *console) setTimeout(args []string) error {
+	if len(args) > 0 {
+		seconds, err := positiveArg(args, 0)
+		if err != nil {
+			return err
+		}
+		c.timeout = time.Duration(seconds) * time.Second
+		fmt.Printf("Run timeout set to %v\n", c.timeout)
+		return nil
+	}
+	fmt.Printf("Run timeout is %v\n", c.timeout)
+	return nil
+}
+
+// detect shows or sets infinite loop detection
+func (c *console) detect(args []string) error {
+	if len(args) > 0 {
+		setting, err := choiceArg(args, 0, "ON", "OFF")
+		if err != nil {
+			return err
+		}
+		c.vm().SetLoopDetection(setting == "ON")
+	}
+	if c.vm().LoopDetection() {
+		fmt.Println("Infinite loop detection is on")
+	} else {
+		fmt.Println("Infinite loop detection is off")
+	}
+	return nil
+}
+
+// engine shows or selects how instructions are executed
+func (c *console) engine(args []string) error {
+	if len(args) > 0 {
+		engine, err := choiceArg(args, 0, "INTERPRETER", "THREADED")
+		if err != nil {
+			return err
+		}
+		if engine == "THREADED" {
+			c.vm().SetEngine(ThreadedEngine)
+		} else {
+			c.vm().SetEngine(InterpreterEngine)
+		}
+	}
+	fmt.Printf("Using the %s engine\n", c.vm().Engine())
+	return nil
+}
+
+// trace shows or sets tracing of control transfers
+func (c *console) trace(args []string) error {
+	if len(args) > 0 {
+		setting, err := choiceArg(args, 0, "ON", "OFF")
+		if err != nil {
+			return err
+		}
+		if setting == "ON" {
+			c.vm().EnableTrace()
+		} else {
+			c.vm().DisableTrace()
+		}
+	}
+	if c.vm().Trace() != nil {
+		fmt.Println("Tracing is on")
+	} else {
+		fmt.Println("Tracing is off")
+	}
+	return nil
+}
+
+// cfg writes the control-flow graph of the program in DOT format to stdout or a file
+func (c *console) cfg(args []string) error {
+	cfg := BuildCFG(c.vm().memory, c.vm().Trace(), c.vm().IP())
+	if len(args) == 0 {
+		cfg.WriteDOT(os.Stdout)
+		return nil
+	}
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	cfg.WriteDOT(file)
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Control-flow graph written to %s\n", args[0])
+	return nil
+}
+
+// block shows the basic block containing the IP
+func (c *console) block(args []string) error {
+	block := BuildCFG(c.vm().memory, c.vm().Trace(), c.vm().IP()).BlockAt(c.vm().IP())
+	if block == nil {
+		fmt.Printf("No instruction found at %d\n", c.vm().IP())
+		return nil
+	}
+	block.Print(os.Stdout)
+	return nil
+}
+
+// profile reports the profile or turns profiling on or off
+func (c *console) profile(args []string) error {
+	if len(args) > 0 {
+		setting, err := choiceArg(args, 0, "ON", "OFF")
+		if err != nil {
+			return err
+		}
+		if setting == "ON" {
+			c.vm().EnableProfiler()
+			fmt.Println("Profiling enabled")
+		} else {
+			c.vm().DisableProfiler()
+			fmt.Println("Profiling disabled")
+		}
+		return nil
+	}
+	if c.vm().Profiler() == nil {
+		fmt.Println("Profiling is off--enable it using 'PROFILE ON' before running")
+		return nil
+	}
+	c.vm().Profiler().Report(os.Stdout, c.vm(), 10)
+	return nil
+}
+
+// smc reports the self-modifications of the last run or sets how they are detected
+func (c *console) smc(args []string) error {
+	if len(args) == 0 {
+		c.vm().ReportSelfModifications(os.Stdout)
+		return nil
+	}
+	setting, err := choiceArg(args, 0, "OFF", "REPORT", "STRICT")
+	if err != nil {
+		return err
+	}
+	switch setting {
+	case "OFF":
+		c.vm().SetSelfModify(SelfModifyIgnore)
+	case "REPORT":
+		c.vm().SetSelfModify(SelfModifyReport)
+	case "STRICT":
+		c.vm().SetSelfModify(SelfModifyStrict)
+	}
+	fmt.Printf("Self-modifying code detection is %s\n", c.vm().SelfModify())
+	return nil
+}
+
+// compliance shows or sets how strictly runs follow the specification
+func (c *console) compliance(args []string) error {
+	if len(args) > 0 {
+		setting, err := choiceArg(args, 0, "STRICT", "COMPATIBLE")
+		if err != nil {
+			return err
+		}
+		if setting == "STRICT" {
+			c.vm().SetCompliance(ComplianceStrict)
+		} else {
+			c.vm().SetCompliance(ComplianceCompatible)
+		}
+	}
+	fmt.Printf("Spec compliance is %s\n", c.vm().Compliance())
+	return nil
+}
+
+// mapDevice lists the mapped devices or maps a new one
+func (c *console) mapDevice(args []string) error {
+	if len(args) == 0 {
+		if len(c.vm().Devices()) == 0 {
+			fmt.Println("No devices mapped")
+		}
+		for _, m := range c.vm().Devices() {
+			fmt.Println(m)
+		}
+		return nil
+	}
+	kind, err := choiceArg(args, 0, "CONSOLE", "CLOCK", "RANDOM", "FRAME")
+	if err != nil {
+		return err
+	}
+	if (kind == "FRAME" && len(args) != 4) || (kind != "FRAME" && len(args) != 2) {
+		return &usageError{lookupCommand("MAP")}
+	}
+	address, err := intArg(args, 1)
+	if err != nil {
+		return err
+	}
+	var device Device
+	switch kind {
+	case "CONSOLE":
+		device = NewCharDevice(stdin, os.Stdout)
+	case "CLOCK":
+		device = NewClockDevice()
+	case "RANDOM":
+		device = NewRandomDevice(time.Now().UnixNano())
+	case "FRAME":
+		width, err := positiveArg(args, 2)
+		if err != nil {
+			return err
+		}
+		height, err := positiveArg(args, 3)
+		if err != nil {
+			return err
+		}
+		device = NewFramebuffer(width, height)
+	}
+	if err := c.vm().MapDevice(address, device); err != nil {
+		return err
+	}
+	fmt.Printf("Mapped %v\n", DeviceMapping{address, device})
+	return nil
+}
+
+// unmap removes the device mapped at an address
+func (c *console) unmap(args []string) error {
+	address, err := intArg(args, 0)
+	if err != nil {
+		return err
+	}
+	if err := c.vm().UnmapDevice(address); err != nil {
+		return err
+	}
+	fmt.Printf("Device at %d unmapped\n", address)
+	return nil
+}
+
+// screen shows the mapped framebuffers
+func (c *console) screen(args []string) error {
+	shown := false
+	for _, m := range c.vm().Devices() {
+		if frame, ok := m.Device.(*Framebuffer); ok {
+			fmt.Println(m)
+			frame.Render(os.Stdout)
+			shown = true
+		}
+	}
+	if !shown {
+		fmt.Println("No framebuffer mapped--map one using 'MAP FRAME <address> <width> <height>'")
+	}
+	return nil
+}
+
+// snapshot saves the state of the VM to a file or restores it from one
+func (c *console) snapshot(args []string) error {
+	action, err := choiceArg(args, 0, "SAVE", "LOAD")
+	if err != nil {
+		return err
+	}
+	if action == "LOAD" {
+		if err := c.session.load(args[1], "", true); err != nil {
+			return err
+		}
+		printSnapshot(c.vm())
+		return nil
+	}
+	if c.vm().Size() == 0 {
+		return errNotLoaded
+	}
+	if err := c.vm().SaveSnapshot(args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot written to %s\n", args[1])
+	return nil
+}
+
+// input queues values for the program to read and shows the queue
+func (c *console) input(args []string) error {
+	vals := make([]int, len(args))
+	for i := range args {
+		val, err := intArg(args, i)
+		if err != nil {
+			return err
+		}
+		vals[i] = val
+	}
+	fmt.Printf("Pending input: %v\n", c.session.giveInput(vals))
+	return nil
+}
+
+// outputs shows the program's outputs
+func (c *console) outputs(args []string) error {
+	printOutputs(c.vm())
+	return nil
+}
+
+// clearOutputs discards the program's outputs
+func (c *console) clearOutputs(args []string) error {
+	c.vm().ClearOutputs()
+	fmt.Println("Outputs cleared")
+	return nil
+}
+
+// newSession starts a session, optionally loading a program into it, and switches to it
+func (c *console) newSession(args []string) error {
+	if c.sessions[args[0]] != nil {
+		return fmt.Errorf("session '%s' already exists", args[0])
+	}
+	created := newConsoleSession(args[0])
+	if len(args) > 1 {
+		if err := created.load(args[1], "", false); err != nil {
+			return err
+		}
+	}
+	c.sessions[created.name] = created
+	c.use(created)
+	fmt.Printf("Using new session '%s'\n", created.name)
+	return nil
+}
+
+// useSession switches to another session
+func (c *console) useSession(args []string) error {
+	if c.sessions[args[0]] == nil {
+		return fmt.Errorf("no session named '%s'--list them using 'LIST'", args[0])
+	}
+	c.use(c.sessions[args[0]])
+	fmt.Printf("Using session '%s'\n", c.session.name)
+	return nil
+}
+
+// listSessions lists the sessions
+func (c *console) listSessions(args []string) error {
+	c.sessions.print(c.session.name)
+	return nil
+}
+
+// closeSession discards a session other than the one in use
+func (c *console) closeSession(args []string) error {
+	if c.sessions[args[0]] == nil {
+		return fmt.Errorf("no session named '%s'", args[0])
+	}
+	if args[0] == c.session.name {
+		return fmt.Errorf("cannot close the session in use--switch to another using 'USE <name>' first")
+	}
+	if c.sessions[args[0]].busy() {
+		return fmt.Errorf("'%s' is running--wait for it to stop first", args[0])
+	}
+	delete(c.sessions, args[0])
+	fmt.Printf("Session '%s' closed\n", args[0])
+	return nil
+}
+
+// diff compares the memory of a session with the one in use, or of two sessions
+func (c *console) diff(args []string) error {
+	a, b := c.session, c.sessions[args[0]]
+	if len(args) > 1 {
+		a, b = c.sessions[args[0]], c.sessions[args[1]]
+	}
+	if a == nil || b == nil {
+		return fmt.Errorf("no such session--list them using 'LIST'")
+	}
+	if a.busy() || b.busy() {
+		return errRunning
+	}
+	printMemoryDiff(a, b)
+	return nil
+}
+
+// help lists the commands, or describes one
+func (c *console) help(args []string) error {
+	if len(args) > 0 {
+		cmd := lookupCommand(args[0])
+		if cmd == nil {
+			return fmt.Errorf("no command named '%s'", args[0])
+		}
+		fmt.Printf("%s\n\t%s\n", cmd.usage(), cmd.help)
+		if len(cmd.aliases) > 0 {
+			fmt.Printf("\talso %s\n", strings.Join(cmd.aliases, ", "))
+		}
+		return nil
+	}
+	fmt.Println("Command options:")
+	for _, cmd := range consoleCommands {
+		names := strings.Join(append([]string{cmd.name}, cmd.aliases...), "|")
+		fmt.Printf("\t%s\n", strings.TrimSpace(names+" "+cmd.args))
+	}
+	fmt.Println("Use 'HELP <command>' for a description of a command")
+	return nil
+}
+
+// exit leaves the console
+func (c *console) exit(args []string) error {
+	for _, session := range c.sessions {
+		if session.pause() != nil {
+			session.wait()
+		}
+	}
+	c.quit = true
+	return nil
+}
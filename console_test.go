@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestModeCommandsRejectBadArguments(t *testing.T) {
+	c := newConsole()
+	c.vm().SetSelfModify(SelfModifyReport)
+	c.vm().SetCompliance(ComplianceStrict)
+	for _, name := range []string{"SMC", "COMPLIANCE"} {
+		var badArg *argError
+		if err := lookupCommand(name).run(c, []string{"BOGUS"}); !errors.As(err, &badArg) {
+			t.Errorf("%s BOGUS: got error %v, want a bad argument", name, err)
+		}
+	}
+	if mode := c.vm().SelfModify(); mode != SelfModifyReport {
+		t.Errorf("SMC BOGUS changed the mode to %v", mode)
+	}
+	if mode := c.vm().Compliance(); mode != ComplianceStrict {
+		t.Errorf("COMPLIANCE BOGUS changed the mode to %v", mode)
+	}
+}
+
+func TestReadAndWriteLeaveProgramUnaware(t *testing.T) {
+	c := newConsole()
+	if _, err := c.vm().LoadFrom(strings.NewReader("1,0,0,0,99")); err != nil {
+		t.Fatal(err)
+	}
+	profiler := c.vm().EnableProfiler()
+	if err := c.vm().MapDevice(3, NewCharDevice(strings.NewReader("A"), io.Discard)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lookupCommand("WRITE").run(c, []string{"1", "7"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lookupCommand("READ").run(c, []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	var badArg *argError
+	if err := lookupCommand("READ").run(c, []string{"3"}); !errors.As(err, &badArg) {
+		t.Errorf("READ of a device address: got error %v, want a bad argument", err)
+	}
+	if c.vm().memory[1] != 7 || profiler.usageOf(1) != 0 {
+		t.Errorf("after WRITE memory holds %v and the profiler saw %v", c.vm().memory, profiler.usageOf(1))
+	}
+	if val := c.vm().ModeRead(3, ImmediateMode); val != 'A' {
+		t.Errorf("the program read %d from the device, want its first input", val)
+	}
+}
+
+func TestLoadClearsTrace(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.txt"), filepath.Join(dir, "second.txt")
+	if err := os.WriteFile(first, []byte("1101,0,0,5,1105,1,7,99"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("1,0,0,0,99"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	registerDay5Opcodes(t)
+	s := newConsoleSession("test")
+	s.vm.EnableTrace()
+	if err := s.load(first, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.vm.Run(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.load(second, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if s.vm.Trace() == nil || len(s.vm.Trace().edges) != 0 {
+		t.Errorf("after loading another image the trace holds %v", s.vm.Trace())
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Background runs of console sessions
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// runState is what the program of a console session is doing
+type runState int
+
+const (
+	// runIdle means the program has not run since it was loaded
+	runIdle runState = iota
+	// runRunning means the program is running in the background
+	runRunning
+	// runPaused means the run was interrupted and can be resumed
+	runPaused
+	// runHalted means the program reached a halt instruction
+	runHalted
+	// runStopped means the run was stopped by an error or a limit
+	runStopped
+)
+
+// String formats the state for display
+func (s runState) String() string {
+	return [...]string{"idle", "running", "paused", "halted", "stopped"}[s]
+}
+
+// consoleJob is a run of a console session's program in progress.  Its fields other than done
+// are guarded by the session's mutex.
+type consoleJob struct {
+	cancel  context.CancelFunc
+	done    chan struct{} // closed once the run has stopped
+	started time.Time
+	paused  bool          // whether the run was stopped by pausing it
+	waiting bool          // whether the program is waiting for input
+	pending []int         // input given while the run is in progress
+	wake    chan struct{} // signalled when input is given
+}
+
+// busy reports whether the session's program is running
+func (s *consoleSession) busy() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.job != nil
+}
+
+// start runs, or resumes, the session's program in a new goroutine, calling report with a
+// description of how the run stopped once it has.  In the background input is taken from
+// giveInput rather than asked for, since the console is still reading commands.
+func (s *consoleSession) start(resume, background bool, timeout time.Duration, report func(string)) (*consoleJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.job != nil {
+		return nil, fmt.Errorf("'%s' is already running", s.name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	job := &consoleJob{cancel: cancel, done: make(chan struct{}), started: time.Now(), wake: make(chan struct{}, 1)}
+	s.job = job
+	s.state = runRunning
+	s.runErr = nil
+	if background {
+		s.vm.SetInputFallback(func() (int, error) { return s.awaitInput(ctx, job, report) })
+	}
+
+	go func() {
+		err := s.run(ctx, resume)
+		cancel()
+
+		s.mutex.Lock()
+		s.vm.SetInputFallback(consoleInput)
+		s.vm.QueueInput(job.pending...)
+		switch {
+		case job.paused:
+			s.state = runPaused
+		case err == nil:
+			s.state = runHalted
+		default:
+			s.state = runStopped
+			s.runErr = err
+		}
+		s.job = nil
+		s.mutex.Unlock()
+		report(s.status())
+		close(job.done)
+	}()
+	return job, nil
+}
+
+// run runs, or resumes, the session's program.  A panic the VM does not turn into an error
+// itself stops the run with an error rather than ending the console along with the goroutine.
+func (s *consoleSession) run(ctx context.Context, resume bool) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the VM crashed: %v", r)
+		}
+	}()
+	if resume {
+		return s.vm.ResumeContext(ctx, false)
+	}
+	return s.vm.RunContext(ctx, false)
+}
+
+// awaitInput supplies input to the program of a background run, waiting for it to be given if
+// none is pending
+func (s *consoleSession) awaitInput(ctx context.Context, job *consoleJob, report func(string)) (int, error) {
+	announced := false
+	for {
+		s.mutex.Lock()
+		if len(job.pending) > 0 {
+			val := job.pending[0]
+			job.pending = job.pending[1:]
+			job.waiting = false
+			s.mutex.Unlock()
+			return val, nil
+		}
+		job.waiting = true
+		s.mutex.Unlock()
+		if !announced {
+			report(fmt.Sprintf("'%s' is waiting for input--give it using 'INPUT <value>...'", s.name))
+			announced = true
+		}
+		select {
+		case <-job.wake:
+		case <-ctx.Done():
+			s.mutex.Lock()
+			job.waiting = false
+			s.mutex.Unlock()
+			return 0, fmt.Errorf("interrupted while waiting for input: %w", ctx.Err())
+		}
+	}
+}
+
+// giveInput queues values for the session's program, handing them to the run in progress if
+// there is one
+func (s *consoleSession) giveInput(vals []int) []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.job == nil {
+		s.vm.QueueInput(vals...)
+		return s.vm.PendingInputs()
+	}
+	s.job.pending = append(s.job.pending, vals...)
+	select {
+	case s.job.wake <- struct{}{}:
+	default:
+	}
+	return s.job.pending
+}
+
+// wait waits for the run in progress to stop, returning false if the program was not running
+func (s *consoleSession) wait() bool {
+	s.mutex.Lock()
+	job := s.job
+	s.mutex.Unlock()
+	if job == nil {
+		return false
+	}
+	<-job.done
+	return true
+}
+
+// pause stops the run in progress so that it can be resumed, returning the run or nil if the
+// program was not running
+func (s *consoleSession) pause() *consoleJob {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.job == nil {
+		return nil
+	}
+	s.job.paused = true
+	s.job.cancel()
+	return s.job
+}
+
+// status describes what the session's program is doing
+func (s *consoleSession) status() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.job != nil {
+		text := fmt.Sprintf("'%s' is running for %v", s.name, time.Since(s.job.started).Round(time.Millisecond))
+		if s.job.waiting {
+			text += " and waiting for input"
+		}
+		return text
+	}
+	switch s.state {
+	case runIdle:
+		return fmt.Sprintf("'%s' is idle at IP %d", s.name, s.vm.IP())
+	case runPaused:
+		text := fmt.Sprintf("'%s' paused at IP %d after %d steps", s.name, s.vm.IP(), s.vm.Steps())
+		if si, ok := decodeAt(s.vm.memory, s.vm.IP()); ok {
+			text += fmt.Sprintf("\n%4d:\t%s", si.address, disassemble(si))
+		}
+		return text + "\nContinue using 'RUN RESUME'"
+	case runHalted:
+		return fmt.Sprintf("'%s' halted at IP %d after %d steps", s.name, s.vm.IP(), s.vm.Steps())
+	default:
+		return fmt.Sprintf("'%s' stopped at IP %d after %d steps: %v", s.name, s.vm.IP(), s.vm.Steps(), s.runErr)
+	}
+}
@@ -0,0 +1,547 @@
+/*
+ * Decompiler from Intcode program images to structured pseudocode
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// noBlock stands for the end of the program when walking the control-flow graph
+const noBlock = -1
+
+// decompiledLine is a line of pseudocode
+type decompiledLine struct {
+	depth int    // indentation level
+	text  string // statement
+	block int    // address of the block the line starts, noBlock if it starts none
+}
+
+// loopRegion is a natural loop being written out
+type loopRegion struct {
+	header int          // address of the block control returns to on each iteration
+	exit   int          // address of the block the loop breaks out to, noBlock if it never does
+	body   map[int]bool // addresses of the blocks in the loop
+}
+
+// decompiler turns the control-flow graph of an image into structured pseudocode
+type decompiler struct {
+	g        *CFG
+	blocks   map[int]*BasicBlock // blocks by starting address
+	code     map[int]bool        // cells holding instructions reachable from address 0
+	names    map[int]bool        // data cells referred to by name
+	headers  map[int]bool        // blocks that are the targets of back edges
+	loops    map[int]*loopRegion // natural loops by header
+	ipdom    map[int]int         // immediate post-dominator of each block, noBlock at the end
+	emitted  map[int]bool        // blocks already written out
+	targets  map[int]bool        // blocks jumped to with a goto
+	writers  map[int]int         // first reachable instruction found writing each cell
+	lines    []decompiledLine
+	warnings []string
+}
+
+// successors returns the successors of a block, the taken target of a conditional jump first
+func (d *decompiler) successors(b *BasicBlock) []int {
+	last := b.code[len(b.code)-1]
+	succs, _ := successorsOf(last)
+	return succs
+}
+
+// dominators computes the dominator sets of the blocks, or with reverse set their
+// post-dominator sets, returning also the blocks where the program can end.  Blocks that
+// cannot reach such a block keep every block as a post-dominator.
+func (d *decompiler) dominators(reverse bool) (map[int]map[int]bool, []int) {
+	preds := map[int][]int{}
+	roots := []int{}
+	for _, b := range d.g.Blocks {
+		succs := d.successors(b)
+		if len(succs) == 0 {
+			roots = append(roots, b.Start)
+		}
+		for _, s := range succs {
+			if _, ok := d.blocks[s]; !ok {
+				roots = append(roots, b.Start)
+				continue
+			}
+			if reverse {
+				preds[b.Start] = append(preds[b.Start], s)
+			} else {
+				preds[s] = append(preds[s], b.Start)
+			}
+		}
+	}
+	ends := roots
+	if !reverse {
+		roots = []int{0}
+	}
+
+	all := map[int]bool{}
+	for address := range d.blocks {
+		all[address] = true
+	}
+	dom := map[int]map[int]bool{}
+	for address := range d.blocks {
+		dom[address] = all
+	}
+	for _, root := range roots {
+		dom[root] = map[int]bool{root: true}
+	}
+	isRoot := map[int]bool{}
+	for _, root := range roots {
+		isRoot[root] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range d.g.Blocks {
+			if isRoot[b.Start] {
+				continue
+			}
+			var meet map[int]bool
+			for _, p := range preds[b.Start] {
+				if meet == nil {
+					meet = map[int]bool{}
+					for address := range dom[p] {
+						meet[address] = true
+					}
+					continue
+				}
+				for address := range meet {
+					if !dom[p][address] {
+						delete(meet, address)
+					}
+				}
+			}
+			if meet == nil {
+				continue
+			}
+			meet[b.Start] = true
+			if len(meet) != len(dom[b.Start]) {
+				dom[b.Start] = meet
+				changed = true
+			}
+		}
+	}
+	return dom, ends
+}
+
+// findLoops finds the natural loops of the graph from its back edges
+func (d *decompiler) findLoops() {
+	dom, _ := d.dominators(false)
+	for _, b := range d.g.Blocks {
+		for _, s := range d.successors(b) {
+			if _, ok := d.blocks[s]; !ok || !dom[b.Start][s] {
+				continue
+			}
+			d.headers[s] = true
+			loop := d.loops[s]
+			if loop == nil {
+				loop = &loopRegion{header: s, exit: noBlock, body: map[int]bool{s: true}}
+				d.loops[s] = loop
+			}
+
+			// Everything reaching the back edge without passing through the header is in the loop
+			work := []int{b.Start}
+			for len(work) > 0 {
+				address := work[len(work)-1]
+				work = work[:len(work)-1]
+				if loop.body[address] {
+					continue
+				}
+				loop.body[address] = true
+				for _, p := range d.g.Blocks {
+					for _, ps := range d.successors(p) {
+						if ps == address {
+							work = append(work, p.Start)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Each loop breaks out to the lowest addressed block outside it; other exits become gotos
+	for _, loop := range d.loops {
+		for address := range loop.body {
+			for _, s := range d.successors(d.blocks[address]) {
+				if _, ok := d.blocks[s]; ok && !loop.body[s] && (loop.exit == noBlock || s < loop.exit) {
+					loop.exit = s
+				}
+			}
+		}
+	}
+}
+
+// findJoins finds the immediate post-dominator of each block, where the paths leaving it meet
+func (d *decompiler) findJoins() {
+	pdom, ends := d.dominators(true)
+
+	// Blocks that never reach the end of the program have no post-dominators
+	reachesEnd := map[int]bool{}
+	for work := ends; len(work) > 0; {
+		address := work[len(work)-1]
+		work = work[:len(work)-1]
+		if reachesEnd[address] {
+			continue
+		}
+		reachesEnd[address] = true
+		for _, b := range d.g.Blocks {
+			if containsInt(d.successors(b), address) {
+				work = append(work, b.Start)
+			}
+		}
+	}
+
+	for address, set := range pdom {
+		d.ipdom[address] = noBlock
+		if !reachesEnd[address] {
+			continue
+		}
+		for candidate := range set {
+			if candidate == address {
+				continue
+			}
+			// The immediate post-dominator is the one post-dominated by every other
+			immediate := true
+			for other := range set {
+				if other != address && other != candidate && !pdom[candidate][other] {
+					immediate = false
+					break
+				}
+			}
+			if immediate {
+				d.ipdom[address] = candidate
+				break
+			}
+		}
+	}
+}
+
+// name returns the pseudocode name of a memory cell
+func (d *decompiler) name(address int) string {
+	switch {
+	case d.code[address]:
+		return fmt.Sprintf("code[%d]", address)
+	case address >= 0 && address < len(d.g.image):
+		d.names[address] = true
+		return fmt.Sprintf("v%d", address)
+	default:
+		return fmt.Sprintf("mem[%d]", address)
+	}
+}
+
+// operand returns the pseudocode for the value of an instruction's parameter
+func (d *decompiler) operand(si staticInstruction, param int) string {
+	if si.modes[param] == ImmediateMode {
+		return fmt.Sprintf("%d", si.params[param])
+	}
+	return d.name(si.params[param])
+}
+
+// call returns the pseudocode for an instruction with no better form as a call of its
+// mnemonic, passing the parameters written as references and leaving out any jump target
+func (d *decompiler) call(si staticInstruction) string {
+	args := []string{}
+	for param := range si.params {
+		switch {
+		case si.inst.jump != nil && param == si.inst.jump.target:
+		case containsInt(si.inst.writes, param):
+			args = append(args, "&"+d.name(si.writeTarget(param)))
+		default:
+			args = append(args, d.operand(si, param))
+		}
+	}
+	return fmt.Sprintf("%s(%s)", si.inst.mnemonic, strings.Join(args, ", "))
+}
+
+// statement returns the pseudocode for an instruction that neither jumps nor halts
+func (d *decompiler) statement(si staticInstruction) string {
+	if operator, ok := goOperators[si.inst.opcode]; ok {
+		return fmt.Sprintf("%s = %s %s %s", d.name(si.writeTarget(2)),
+			d.operand(si, 0), operator, d.operand(si, 1))
+	}
+	return d.call(si)
+}
+
+// line adds a line of pseudocode
+func (d *decompiler) line(depth int, format string, args ...interface{}) {
+	d.lines = append(d.lines, decompiledLine{depth: depth, text: fmt.Sprintf(format, args...), block: noBlock})
+}
+
+// fault writes the end of a path that reaches an address holding no instruction, noting when
+// the program writes an instruction there that could only be followed with a trace
+func (d *decompiler) fault(depth, address int) {
+	writer, written := d.writers[address]
+	switch {
+	case address < 0 || address >= len(d.g.image):
+		d.line(depth, "fault // %d is outside memory", address)
+	case written && decodeError(d.g.image[address]) != nil:
+		d.line(depth, "fault // %v at %d unless overwritten at runtime by %d--decompile with a trace to follow it",
+			decodeError(d.g.image[address]), address, writer)
+	case decodeError(d.g.image[address]) != nil:
+		d.line(depth, "fault // %v at %d", decodeError(d.g.image[address]), address)
+	default:
+		d.line(depth, "fault // no halt instruction before the end of memory at %d", address)
+	}
+}
+
+// goTo writes a jump to a block that cannot be expressed structurally
+func (d *decompiler) goTo(depth, address int) {
+	d.targets[address] = true
+	d.line(depth, "goto L%d", address)
+}
+
+// region writes the blocks from cur until control reaches stop, inside the passed loop if any
+func (d *decompiler) region(cur, stop int, loop *loopRegion, depth int) {
+	for {
+		block, ok := d.blocks[cur]
+		switch {
+		case cur == stop:
+			return
+		case !ok:
+			d.fault(depth, cur)
+			return
+		case loop != nil && cur == loop.header && d.emitted[cur]:
+			d.line(depth, "continue")
+			return
+		case loop != nil && cur == loop.exit:
+			d.line(depth, "break")
+			return
+		case loop != nil && !loop.body[cur], d.emitted[cur]:
+			d.goTo(depth, cur)
+			return
+		case d.headers[cur] && (loop == nil || loop.header != cur):
+			inner := d.loops[cur]
+			d.line(depth, "for {")
+			d.region(cur, noBlock-1, inner, depth+1)
+			if last := d.lines[len(d.lines)-1]; last.depth == depth+1 && last.text == "continue" {
+				d.lines = d.lines[:len(d.lines)-1] // the loop repeats anyway
+			}
+			d.line(depth, "}")
+			if inner.exit == noBlock {
+				return
+			}
+			cur = inner.exit
+			continue
+		}
+
+		d.emitted[cur] = true
+		d.lines = append(d.lines, decompiledLine{depth: -1, block: cur})
+		for _, si := range block.code[:len(block.code)-1] {
+			d.line(depth, "%s", d.statement(si))
+		}
+		last := block.code[len(block.code)-1]
+		switch {
+		case last.inst.halts:
+			d.line(depth, "halt")
+			return
+		case last.inst.jump == nil:
+			d.line(depth, "%s", d.statement(last))
+			cur = last.end()
+			continue
+		}
+
+		// Jumps with a target in memory cannot be followed statically
+		jump := last.inst.jump
+		if last.modes[jump.target] != ImmediateMode {
+			if !jump.conditional {
+				d.line(depth, "goto *%s", d.name(last.params[jump.target]))
+				return
+			}
+			d.line(depth, "if %s {", d.call(last))
+			d.line(depth+1, "goto *%s", d.name(last.params[jump.target]))
+			d.line(depth, "}")
+			cur = last.end()
+			continue
+		}
+		taken := last.params[jump.target]
+		if !jump.conditional {
+			cur = taken
+			continue
+		}
+		join := d.ipdom[cur]
+		if join == noBlock {
+			join = noBlock - 1 // neither branch rejoins the other
+		}
+		switch {
+		case taken == join:
+			d.line(depth, "if !%s {", d.call(last))
+			d.region(last.end(), join, loop, depth+1)
+		default:
+			d.line(depth, "if %s {", d.call(last))
+			d.region(taken, join, loop, depth+1)
+			if last.end() != join {
+				d.line(depth, "} else {")
+				d.region(last.end(), join, loop, depth+1)
+			}
+		}
+		d.line(depth, "}")
+		if join < 0 {
+			return
+		}
+		cur = join
+	}
+}
+
+// Decompile writes pseudocode for the program in an image to w, recovering if/else statements
+// and loops from its control flow.  Cells holding code are shown as code[address], other
+// cells the program uses as data as named variables.  Control flow that cannot be structured
+// is shown with labels and gotos.  If a trace of the program is passed, code the program only
+// writes at runtime, such as instructions it patches before running them, is decompiled as
+// the trace saw it executed.  Function calls are not recovered since the VM has no relative
+// base instructions for programs to build call frames with.
+func Decompile(w io.Writer, source string, image []int, trace *Trace) {
+
+	d := &decompiler{
+		g:       BuildCFG(image, trace),
+		blocks:  map[int]*BasicBlock{},
+		code:    map[int]bool{},
+		names:   map[int]bool{},
+		headers: map[int]bool{},
+		loops:   map[int]*loopRegion{},
+		ipdom:   map[int]int{},
+		emitted: map[int]bool{},
+		targets: map[int]bool{},
+		writers: map[int]int{},
+	}
+	for _, b := range d.g.Blocks {
+		d.blocks[b.Start] = b
+		for _, si := range b.code {
+			for address := si.address; address < si.end(); address++ {
+				d.code[address] = true
+			}
+		}
+	}
+	modified := map[int]bool{}
+	for _, b := range d.g.Blocks {
+		for _, si := range b.code {
+			for _, param := range si.inst.writes {
+				target := si.writeTarget(param)
+				if _, ok := d.writers[target]; !ok {
+					d.writers[target] = si.address
+				}
+				if d.code[target] && d.g.image[target] == image[target] {
+					modified[target] = true // code overlaid from the trace is noted separately
+				}
+			}
+		}
+	}
+	if len(modified) > 0 {
+		addresses := []int{}
+		for address := range modified {
+			addresses = append(addresses, address)
+		}
+		sort.Ints(addresses)
+		d.warnings = append(d.warnings, fmt.Sprintf("the program writes to its own code at %s; "+
+			"the listing shows the code as loaded", strings.Trim(fmt.Sprint(addresses), "[]")))
+	}
+	if traced := collectRanges(len(image), func(address int) bool {
+		return d.g.image[address] != image[address]
+	}); len(traced) > 0 {
+		d.warnings = append(d.warnings, fmt.Sprintf("the code at %s is shown as the trace saw it executed "+
+			"rather than as loaded", strings.Trim(fmt.Sprint(traced), "[]")))
+	}
+
+	if len(d.blocks) > 0 {
+		d.findLoops()
+		d.findJoins()
+		d.region(0, noBlock-1, nil, 1)
+	} else {
+		d.fault(1, 0)
+	}
+
+	// Blocks only reached through gotos from blocks written later are written after the rest
+	for pending := true; pending; {
+		pending = false
+		for _, b := range d.g.Blocks {
+			if d.targets[b.Start] && !d.emitted[b.Start] {
+				d.region(b.Start, noBlock-1, nil, 1)
+				pending = true
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "// Decompiled from %s\n", source)
+	for _, warning := range d.warnings {
+		fmt.Fprintf(w, "// Warning: %s\n", warning)
+	}
+	if len(d.names) > 0 {
+		addresses := []int{}
+		for address := range d.names {
+			addresses = append(addresses, address)
+		}
+		sort.Ints(addresses)
+		fmt.Fprintln(w, "var (")
+		for _, address := range addresses {
+			fmt.Fprintf(w, "\tv%d = %d\n", address, image[address])
+		}
+		fmt.Fprintln(w, ")")
+	}
+	fmt.Fprintln(w, "\nfunc main() {")
+	for _, l := range d.lines {
+		if l.depth < 0 {
+			if d.targets[l.block] {
+				fmt.Fprintf(w, "L%d:\n", l.block)
+			}
+			continue
+		}
+		fmt.Fprintf(w, "%s%s\n", strings.Repeat("\t", l.depth), l.text)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// decompileTool writes pseudocode for the Intcode image named by the first argument to the file
+// named by the second argument, or to standard output if there is none.  '-trace <inputs>'
+// first runs the image with the comma separated inputs so that code it writes at runtime can
+// be followed.
+func decompileTool(args []string) int {
+	var inputs []int
+	traced := len(args) > 1 && args[0] == "-trace"
+	if traced {
+		for _, field := range strings.FieldsFunc(args[1], func(r rune) bool { return r == ',' }) {
+			val, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				fmt.Printf("Error: bad input '%s'\n", field)
+				return 2
+			}
+			inputs = append(inputs, val)
+		}
+		args = args[2:]
+	}
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Println("Usage: decompile [-trace <input>,...] <image file> [<output file>]")
+		return 2
+	}
+	vm, err := new(VM).Load(args[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	var trace *Trace
+	if traced {
+		run := vm.Clone()
+		trace = run.EnableTrace()
+		run.SetMaxSteps(consoleMaxSteps)
+		run.QueueInput(inputs...)
+		if err := run.Run(false); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: the traced run stopped with: %v\n", err)
+		}
+	}
+	w := io.Writer(os.Stdout)
+	if len(args) == 2 {
+		file, err := os.Create(args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	Decompile(w, args[0], vm.memory, trace)
+	return 0
+}
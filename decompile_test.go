@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// decompileString decompiles an image, first tracing a run of it with the passed input if
+// traced is set
+func decompileString(t *testing.T, source string, traced bool, inputs ...int) string {
+	t.Helper()
+	vm := loadProgram(t, source, InterpreterEngine)
+	var trace *Trace
+	if traced {
+		run := vm.Clone()
+		trace = run.EnableTrace()
+		run.QueueInput(inputs...)
+		if err := run.Run(false); err != nil {
+			t.Fatalf("tracing %s: %v", source, err)
+		}
+	}
+	var b strings.Builder
+	Decompile(&b, source, vm.memory, trace)
+	return b.String()
+}
+
+func TestDecompileGolden(t *testing.T) {
+	registerDay5Opcodes(t)
+	tests := []struct {
+		name   string
+		source string
+		traced bool
+		want   string
+	}{
+		{"05-A example", "1002,4,3,4,33", false, `// Decompiled from 1002,4,3,4,33
+var (
+	v4 = 33
+)
+
+func main() {
+	v4 = v4 * 3
+	fault // Invalid opcode 33 at 4 unless overwritten at runtime by 0--decompile with a trace to follow it
+}
+`},
+		{"05-A example traced", "1002,4,3,4,33", true, `// Decompiled from 1002,4,3,4,33
+// Warning: the code at 4 is shown as the trace saw it executed rather than as loaded
+
+func main() {
+	code[4] = code[4] * 3
+	halt
+}
+`},
+		{"05-B compare to 8", "3,3,1108,-1,8,3,4,3,99", false, `// Decompiled from 3,3,1108,-1,8,3,4,3,99
+// Warning: the program writes to its own code at 3; the listing shows the code as loaded
+
+func main() {
+	IN(&code[3])
+	EQ(-1, 8, &code[3])
+	OUT(code[3])
+	halt
+}
+`},
+		{"05-B jump", "3,12,6,12,15,1,13,14,13,4,13,99,-1,0,1,9", false, `// Decompiled from 3,12,6,12,15,1,13,14,13,4,13,99,-1,0,1,9
+var (
+	v12 = -1
+	v13 = 0
+	v14 = 1
+	v15 = 9
+)
+
+func main() {
+	IN(&v12)
+	if JZ(v12) {
+		goto *v15
+	}
+	v13 = v13 + v14
+	OUT(v13)
+	halt
+}
+`},
+		{"day05test01", "data/day05test01.txt", false, `// Decompiled from data/day05test01.txt
+// Warning: the program writes to its own code at 0; the listing shows the code as loaded
+
+func main() {
+	IN(&code[0])
+	OUT(code[0])
+	halt
+}
+`},
+		{"day05test02 traced", "data/day05test02.txt", true, `// Decompiled from data/day05test02.txt
+// Warning: the code at 4 is shown as the trace saw it executed rather than as loaded
+
+func main() {
+	code[4] = 100 + -1
+	halt
+}
+`},
+	}
+	for _, test := range tests {
+		if got := decompileString(t, test.source, test.traced); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDecompileTracedSelfPatchingCode(t *testing.T) {
+	registerDay5Opcodes(t)
+	untraced := decompileString(t, "data/day05.txt", false)
+	if !strings.Contains(untraced, "fault // Invalid opcode 0 at 6 unless overwritten at runtime by 2") {
+		t.Errorf("untraced listing does not explain the fault at 6:\n%s", untraced)
+	}
+	traced := decompileString(t, "data/day05.txt", true, 5)
+	if strings.Contains(traced, "Invalid opcode 0 at 6") || !strings.Contains(traced, "the code at 6 is shown as the trace saw it") ||
+		!strings.Contains(traced, "OUT(") {
+		t.Errorf("traced listing does not follow the code patched at 6:\n%s", traced)
+	}
+}
@@ -0,0 +1,255 @@
+/*
+ * Memory-mapped devices for the ship's computer
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Device is implemented in Go and mapped to a range of addresses so that the program's data
+// reads and writes there, through ModeRead and ModeWrite, go to the device instead of memory.
+// Instructions are always fetched from memory.
+type Device interface {
+	// Size returns the number of addresses the device occupies
+	Size() int
+	// Read returns the value of the cell at the passed offset into the device
+	Read(offset int) int
+	// Write stores a value in the cell at the passed offset into the device
+	Write(offset, val int)
+}
+
+// DeviceMapping is a device and the address of its first cell
+type DeviceMapping struct {
+	Address int
+	Device  Device
+}
+
+// String formats the mapping for display
+func (m DeviceMapping) String() string {
+	name := fmt.Sprintf("%T", m.Device)
+	if s, ok := m.Device.(fmt.Stringer); ok {
+		name = s.String()
+	}
+	return fmt.Sprintf("%d-%d: %s", m.Address, m.Address+m.Device.Size()-1, name)
+}
+
+// deviceBus holds the devices mapped into a VM's address space ordered by address
+type deviceBus struct {
+	mappings []DeviceMapping
+}
+
+// lookup returns the device mapped at the passed address and the offset of the address into
+// it, reporting false if no device is mapped there
+func (b *deviceBus) lookup(address int) (Device, int, bool) {
+	i := sort.Search(len(b.mappings), func(i int) bool {
+		return b.mappings[i].Address+b.mappings[i].Device.Size() > address
+	})
+	if i < len(b.mappings) && b.mappings[i].Address <= address {
+		return b.mappings[i].Device, address - b.mappings[i].Address, true
+	}
+	return nil, 0, false
+}
+
+// MapDevice maps a device to the addresses starting at the passed address, which may lie
+// inside memory, hiding the cells there from data accesses, or beyond its end.  Loop detection
+// is suspended while devices are mapped since their state is not part of memory.
+func (vm *VM) MapDevice(address int, d Device) error {
+	if address < 0 {
+		return fmt.Errorf("cannot map a device to the negative address %d", address)
+	}
+	if d.Size() < 1 {
+		return fmt.Errorf("cannot map a device with no cells")
+	}
+	if vm.bus == nil {
+		vm.bus = &deviceBus{}
+	}
+	for _, m := range vm.bus.mappings {
+		if address < m.Address+m.Device.Size() && m.Address < address+d.Size() {
+			return fmt.Errorf("addresses %d-%d overlap the device mapped at %v", address, address+d.Size()-1, m)
+		}
+	}
+	vm.bus.mappings = append(vm.bus.mappings, DeviceMapping{address, d})
+	sort.Slice(vm.bus.mappings, func(i, j int) bool {
+		return vm.bus.mappings[i].Address < vm.bus.mappings[j].Address
+	})
+	return nil
+}
+
+// UnmapDevice removes the device mapped starting at the passed address
+func (vm *VM) UnmapDevice(address int) error {
+	if vm.bus != nil {
+		for i, m := range vm.bus.mappings {
+			if m.Address == address {
+				vm.bus.mappings = append(vm.bus.mappings[:i], vm.bus.mappings[i+1:]...)
+				if len(vm.bus.mappings) == 0 {
+					vm.bus = nil
+				}
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no device is mapped starting at %d", address)
+}
+
+// Devices returns the devices mapped into the VM's address space ordered by address
+func (vm *VM) Devices() []DeviceMapping {
+	if vm.bus == nil {
+		return nil
+	}
+	return append([]DeviceMapping(nil), vm.bus.mappings...)
+}
+
+// CharDevice is a single cell character device.  Reading returns the next byte of its input,
+// or -1 once the input is exhausted, and writing sends a byte to its output.
+type CharDevice struct {
+	r io.Reader
+	w io.Writer
+}
+
+// NewCharDevice returns a character device reading from r and writing to w.  Input is read
+// a byte at a time so that nothing beyond what the program reads is consumed.
+func NewCharDevice(r io.Reader, w io.Writer) *CharDevice {
+	return &CharDevice{r: r, w: w}
+}
+
+// Size returns the number of cells of the device
+func (c *CharDevice) Size() int {
+	return 1
+}
+
+// Read returns the next byte of input or -1 at its end
+func (c *CharDevice) Read(offset int) int {
+	var buf [1]byte
+	if _, err := io.ReadFull(c.r, buf[:]); err != nil {
+		return -1
+	}
+	return int(buf[0])
+}
+
+// Write sends the low byte of the value to the output
+func (c *CharDevice) Write(offset, val int) {
+	c.w.Write([]byte{byte(val)})
+}
+
+// String names the device
+func (c *CharDevice) String() string {
+	return "console"
+}
+
+// ClockDevice is a single cell clock counting milliseconds.  Writing sets the current time.
+type ClockDevice struct {
+	epoch time.Time // time at which the clock read zero
+}
+
+// NewClockDevice returns a clock reading zero now
+func NewClockDevice() *ClockDevice {
+	return &ClockDevice{epoch: time.Now()}
+}
+
+// Size returns the number of cells of the device
+func (c *ClockDevice) Size() int {
+	return 1
+}
+
+// Read returns the number of milliseconds since the clock read zero
+func (c *ClockDevice) Read(offset int) int {
+	return int(time.Since(c.epoch) / time.Millisecond)
+}
+
+// Write sets the clock to read the passed number of milliseconds now
+func (c *ClockDevice) Write(offset, val int) {
+	c.epoch = time.Now().Add(-time.Duration(val) * time.Millisecond)
+}
+
+// String names the device
+func (c *ClockDevice) String() string {
+	return "clock"
+}
+
+// RandomDevice is a single cell source of non-negative pseudo-random numbers.  Writing seeds
+// the source so that runs can be repeated.
+type RandomDevice struct {
+	rng *rand.Rand
+}
+
+// NewRandomDevice returns a random number source with the passed seed
+func NewRandomDevice(seed int64) *RandomDevice {
+	return &RandomDevice{rng: rand.New(rand.NewSource(seed))}
+}
+
+// Size returns the number of cells of the device
+func (r *RandomDevice) Size() int {
+	return 1
+}
+
+// Read returns the next pseudo-random number
+func (r *RandomDevice) Read(offset int) int {
+	return r.rng.Int()
+}
+
+// Write seeds the source
+func (r *RandomDevice) Write(offset, val int) {
+	r.rng.Seed(int64(val))
+}
+
+// String names the device
+func (r *RandomDevice) String() string {
+	return "random"
+}
+
+// Framebuffer is a grid of cells, one per pixel, stored a row at a time
+type Framebuffer struct {
+	width  int
+	height int
+	pixels []int
+}
+
+// NewFramebuffer returns a blank framebuffer of the passed dimensions
+func NewFramebuffer(width, height int) *Framebuffer {
+	return &Framebuffer{width: width, height: height, pixels: make([]int, width*height)}
+}
+
+// Size returns the number of cells of the device
+func (f *Framebuffer) Size() int {
+	return len(f.pixels)
+}
+
+// Read returns the value of a pixel
+func (f *Framebuffer) Read(offset int) int {
+	return f.pixels[offset]
+}
+
+// Write sets the value of a pixel
+func (f *Framebuffer) Write(offset, val int) {
+	f.pixels[offset] = val
+}
+
+// Pixel returns the value of the pixel at the passed coordinates
+func (f *Framebuffer) Pixel(x, y int) int {
+	return f.pixels[y*f.width+x]
+}
+
+// Render draws the framebuffer to w with '#' for pixels that are set and '.' for the rest
+func (f *Framebuffer) Render(w io.Writer) {
+	for y := 0; y < f.height; y++ {
+		line := make([]byte, f.width)
+		for x := range line {
+			line[x] = '.'
+			if f.Pixel(x, y) != 0 {
+				line[x] = '#'
+			}
+		}
+		fmt.Fprintln(w, string(line))
+	}
+}
+
+// String names the device
+func (f *Framebuffer) String() string {
+	return fmt.Sprintf("framebuffer %dx%d", f.width, f.height)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// enginePrograms are run on every execution engine, which must all run them to completion
+// leaving identical memory and outputs.  Sources ending in '.txt' are loaded from disk.
+var enginePrograms = []struct {
+	name   string
+	source string
+	inputs []int
+}{
+	{"day02", "data/day02.txt", nil},
+	{"day05 air conditioner", "data/day05.txt", []int{1}},
+	{"day05 thermal radiator", "data/day05.txt", []int{5}},
+	{"day05test01", "data/day05test01.txt", []int{7}},
+	{"day05test02", "data/day05test02.txt", nil},
+	{"02-A example 1", "1,9,10,3,2,3,11,0,99,30,40,50", nil},
+	{"02-A example 2", "1,0,0,0,99", nil},
+	{"02-A example 3", "2,3,0,3,99", nil},
+	{"02-A example 4", "2,4,4,5,99,0", nil},
+	{"02-A example 5", "1,1,1,4,99,5,6,0,99", nil},
+	{"05-A example", "1002,4,3,4,33", nil},
+	{"05-B compare to 8", "3,3,1108,-1,8,3,4,3,99", []int{8}},
+	{"05-B jump", "3,12,6,12,15,1,13,14,13,4,13,99,-1,0,1,9", []int{0}},
+
+	// Overwrites its first instruction, an ADD, with a MUL after executing it and then loops
+	// back to it, so a stale compiled ADD would output 9 rather than 20
+	{"self-modifying loop", "1,20,21,20,1101,0,2,0,1001,22,-1,22,1005,22,0,4,20,99,0,0,3,2,3", nil},
+
+	// Overwrites its first instruction with a halt after executing it and then jumps back to
+	// it, so a stale compiled instruction would count to 2 rather than 1
+	{"self-modifying halt", "1001,12,1,12,1101,0,99,0,1105,1,0,99,0", nil},
+}
+
+// engineRuns is how many times each program is run on the same VM, restoring its memory in
+// between, so that code compiled during one run and overwritten by it is exercised by the next
+const engineRuns = 2
+
+// loadProgram loads one of the engine programs into a new VM using the passed engine
+func loadProgram(t *testing.T, source string, engine Engine) *VM {
+	t.Helper()
+	var vm *VM
+	var err error
+	if strings.HasSuffix(source, ".txt") {
+		vm, err = new(VM).Load(source)
+	} else {
+		vm, err = new(VM).LoadFrom(strings.NewReader(source))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.SetEngine(engine)
+	vm.SetMaxSteps(100000)
+	return vm
+}
+
+func TestEnginesAgree(t *testing.T) {
+	registerDay5Opcodes(t)
+	for _, program := range enginePrograms {
+		t.Run(program.name, func(t *testing.T) {
+			reference := loadProgram(t, program.source, InterpreterEngine)
+			candidate := loadProgram(t, program.source, ThreadedEngine)
+			image := append([]int(nil), reference.memory...)
+			for run := 1; run <= engineRuns; run++ {
+				for _, vm := range []*VM{reference, candidate} {
+					vm.restore(image)
+					vm.ClearOutputs()
+					vm.QueueInput(program.inputs...)
+					if err := vm.Run(false); err != nil {
+						t.Fatalf("run %d: %v: %v", run, vm.Engine(), err)
+					}
+				}
+				if !reflect.DeepEqual(reference.memory, candidate.memory) {
+					for address := range reference.memory {
+						if reference.memory[address] != candidate.memory[address] {
+							t.Fatalf("run %d: address %d holds %d under %v but %d under %v", run, address,
+								reference.memory[address], reference.Engine(), candidate.memory[address], candidate.Engine())
+						}
+					}
+				}
+				if !reflect.DeepEqual(reference.Outputs(), candidate.Outputs()) {
+					t.Fatalf("run %d: %v output %v but %v output %v", run,
+						reference.Engine(), reference.Outputs(), candidate.Engine(), candidate.Outputs())
+				}
+			}
+		})
+	}
+}
+
+func TestSelfModifyingLoop(t *testing.T) {
+	registerDay5Opcodes(t)
+	for _, engine := range []Engine{InterpreterEngine, ThreadedEngine} {
+		vm := loadProgram(t, "1,20,21,20,1101,0,2,0,1001,22,-1,22,1005,22,0,4,20,99,0,0,3,2,3", engine)
+		if err := vm.Run(false); err != nil {
+			t.Fatalf("%v: %v", engine, err)
+		}
+		if !reflect.DeepEqual(vm.Outputs(), []int{20}) {
+			t.Errorf("%v output %v, want [20]", engine, vm.Outputs())
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ * Instruction-set registry for the ship's computer
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Jump describes how a registered instruction may transfer control somewhere other than the
+// instruction that follows it, so that analyses such as control-flow graphs can follow it
+type Jump struct {
+	Param       int  // index of the parameter holding the destination
+	Conditional bool // whether the instruction may instead continue with the next instruction
+}
+
+// Opcode declares an instruction to add to the VM's instruction set
+type Opcode struct {
+	Number   int                            // value of the opcode, the two rightmost digits of an instruction
+	Mnemonic string                         // name used when displaying the instruction
+	Params   int                            // number of parameters following the opcode
+	Writes   []int                          // indexes of the parameters the instruction writes to
+	Halts    bool                           // whether executing the instruction stops the VM
+	Jump     *Jump                          // how the instruction may jump, nil if it never does
+	Exec     func(vm *VM, args *Args) error // carries out the instruction, nil when it halts
+	Pure     bool                           // whether Exec only reads and writes memory, so it may be replayed
+}
+
+// Args gives an executing instruction access to its parameters
+type Args struct {
+	vm    *VM
+	ip    int        // address of the instruction
+	modes paramModes // modes of the instruction's parameters
+	next  int        // address of the instruction to execute next
+}
+
+// IP returns the address of the executing instruction
+func (a *Args) IP() int {
+	return a.ip
+}
+
+// Mode returns the mode of the parameter with the passed index
+func (a *Args) Mode(param int) ParamMode {
+	return a.modes[param]
+}
+
+// Read returns the value of the parameter with the passed index according to its mode
+func (a *Args) Read(param int) int {
+	return a.vm.ModeRead(a.ip+1+param, a.modes[param])
+}
+
+// Write stores a value through the parameter with the passed index according to its mode
+func (a *Args) Write(param, val int) {
+	a.vm.ModeWrite(a.ip+1+param, val, a.modes[param])
+}
+
+// Input takes the next value from the VM's input queue, returning ErrNoInput if it is empty
+func (a *Args) Input() (int, error) {
+	return a.vm.input()
+}
+
+// Output writes a value to the VM's output
+func (a *Args) Output(val int) {
+	a.vm.output(val)
+}
+
+// Jump continues execution at the passed address rather than the next instruction
+func (a *Args) Jump(address int) {
+	a.next = address
+}
+
+// opcodeGeneration counts changes to the instruction set so that code compiled by the threaded
+// engine before a change is discarded
+var opcodeGeneration int
+
+// RegisterOpcode adds an instruction to the instruction set of every VM.  Built-in opcodes
+// cannot be replaced.  The instruction set is shared, so opcodes should be registered before
+// any program runs, e.g. from an init function.
+func RegisterOpcode(op Opcode) error {
+	switch {
+	case op.Number < 0 || op.Number >= len(dispatch):
+		return fmt.Errorf("opcode %d is not between 0 and %d", op.Number, len(dispatch)-1)
+	case dispatch[op.Number] != nil:
+		return fmt.Errorf("opcode %d is already defined as %s", op.Number, dispatch[op.Number].mnemonic)
+	case op.Mnemonic == "":
+		return fmt.Errorf("opcode %d has no mnemonic", op.Number)
+	case op.Params < 0 || op.Params > maxParams:
+		return fmt.Errorf("%s takes %d parameters but at most %d are allowed", op.Mnemonic, op.Params, maxParams)
+	case op.Halts != (op.Exec == nil):
+		return fmt.Errorf("%s must either halt or have an execute function", op.Mnemonic)
+	case op.Jump != nil && (op.Jump.Param < 0 || op.Jump.Param >= op.Params):
+		return fmt.Errorf("%s jumps to parameter %d which it does not take", op.Mnemonic, op.Jump.Param)
+	}
+	for _, param := range op.Writes {
+		if param < 0 || param >= op.Params {
+			return fmt.Errorf("%s writes to parameter %d which it does not take", op.Mnemonic, param)
+		}
+	}
+
+	inst := instruction{
+		opcode:   op.Number,
+		mnemonic: op.Mnemonic,
+		width:    op.Params + 1,
+		halts:    op.Halts,
+		writes:   append([]int(nil), op.Writes...),
+		extended: true,
+		pure:     op.Pure,
+	}
+	if op.Jump != nil {
+		inst.jump = &jumpInfo{target: op.Jump.Param, conditional: op.Jump.Conditional}
+	}
+	if exec := op.Exec; exec != nil {
+		inst.exec = func(vm *VM, ip int, modes paramModes) int {
+			args := Args{vm: vm, ip: ip, modes: modes, next: ip + op.Params + 1}
+			if err := exec(vm, &args); err != nil {
+				panic(runFault{fmt.Errorf("%s failed: %w", op.Mnemonic, err)})
+			}
+			return args.next
+		}
+	}
+	instructions[op.Number] = inst
+	dispatch[op.Number] = &inst
+	opcodeGeneration++
+	return nil
+}
+
+// UnregisterOpcode removes an instruction added with RegisterOpcode
+func UnregisterOpcode(number int) error {
+	if number < 0 || number >= len(dispatch) || dispatch[number] == nil {
+		return fmt.Errorf("opcode %d is not defined", number)
+	}
+	if !dispatch[number].extended {
+		return fmt.Errorf("opcode %d (%s) is built in", number, dispatch[number].mnemonic)
+	}
+	delete(instructions, number)
+	dispatch[number] = nil
+	opcodeGeneration++
+	return nil
+}
+
+// Opcodes returns the instruction set, built in and registered, ordered by opcode.  The
+// execute functions of built-in instructions are not exposed.
+func Opcodes() []Opcode {
+	ops := []Opcode{}
+	for number, inst := range instructions {
+		op := Opcode{
+			Number:   number,
+			Mnemonic: inst.mnemonic,
+			Params:   inst.width - 1,
+			Writes:   append([]int(nil), inst.writes...),
+			Halts:    inst.halts,
+		}
+		if inst.jump != nil {
+			op.Jump = &Jump{Param: inst.jump.target, Conditional: inst.jump.conditional}
+		}
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Number < ops[j].Number })
+	return ops
+}
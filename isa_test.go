@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnregisteredOpcodeStopsThreadedRuns(t *testing.T) {
+	op := Opcode{Number: 50, Mnemonic: "NOP", Exec: func(vm *VM, a *Args) error { return nil }}
+	if err := RegisterOpcode(op); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { UnregisterOpcode(50) }) // in case the test stops before unregistering it
+	vm := loadString(t, "50,99")
+	vm.SetEngine(ThreadedEngine)
+	if err := vm.Run(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnregisterOpcode(50); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Run(false); err == nil || !strings.Contains(err.Error(), "Invalid opcode 50") {
+		t.Errorf("running an unregistered opcode: got error %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+/*
+ * Line editor for the console
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxHistory is the number of lines the line editor remembers
+const maxHistory = 500
+
+// lineEditor reads lines typed at the console.  When stdin is a terminal it puts it in raw mode
+// while a line is typed to offer editing with the arrow keys, history, and tab completion;
+// otherwise lines are read as they arrive.
+type lineEditor struct {
+	in        *bufio.Reader
+	out       io.Writer
+	fd        int      // file descriptor of stdin, for switching it to raw mode
+	history   []string // lines entered, oldest first
+	complete  func(line string) []string
+	interrupt func() // called when Ctrl-C is typed, which raw mode stops raising SIGINT
+
+	mutex  sync.Mutex // serializes output while a line is being edited
+	redraw func()     // redraws the line being edited, nil when no line is
+}
+
+// stdin is the line editor for the process's standard input, shared so that no buffered input
+// is lost between prompts
+var stdin = &lineEditor{in: bufio.NewReader(os.Stdin), out: os.Stdout, fd: int(os.Stdin.Fd())}
+
+// Read lets programs read the editor's input directly, such as through a mapped CharDevice
+func (e *lineEditor) Read(p []byte) (int, error) {
+	return e.in.Read(p)
+}
+
+// isTerminal reports whether lines are typed at a terminal and so can be edited
+func (e *lineEditor) isTerminal() bool {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return false
+	}
+	restore()
+	return true
+}
+
+// notify displays a message from another goroutine without disturbing a line being edited
+func (e *lineEditor) notify(message string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.redraw == nil {
+		fmt.Fprintln(e.out, message)
+		return
+	}
+	fmt.Fprintf(e.out, "\r\x1b[K%s\r\n", message)
+	e.redraw()
+}
+
+// readLine displays a prompt and returns the line typed without its line ending, or io.EOF
+// once the input is exhausted
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	fmt.Fprint(e.out, prompt)
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return e.readPlain()
+	}
+	defer restore()
+	return e.readEdited(prompt)
+}
+
+// readPlain reads a line from input that is not a terminal
+func (e *lineEditor) readPlain() (string, error) {
+	text, err := e.in.ReadString('\n')
+	if err != nil && (err != io.EOF || text == "") {
+		fmt.Fprintln(e.out)
+		return "", err
+	}
+	return strings.TrimRight(text, "\r\n"), nil
+}
+
+// readEdited reads a line from a terminal in raw mode, echoing and editing it
+func (e *lineEditor) readEdited(prompt string) (string, error) {
+	line := []rune{}
+	cursor := 0
+	recalled := len(e.history) // history entry shown, len(history) for the line being typed
+	typed := ""                // line being typed when history was first recalled
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - cursor; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	recall := func(index int) {
+		if index < 0 || index > len(e.history) {
+			return
+		}
+		if recalled == len(e.history) {
+			typed = string(line)
+		}
+		recalled = index
+		if index == len(e.history) {
+			line = []rune(typed)
+		} else {
+			line = []rune(e.history[index])
+		}
+		cursor = len(line)
+	}
+
+	// key applies a key to the line returning true, and the error ending input if any, once the
+	// line is finished
+	key := func(r rune) (bool, error) {
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			e.remember(string(line))
+			return true, nil
+		case 3: // Ctrl-C abandons the line
+			fmt.Fprint(e.out, "^C\r\n")
+			line = line[:0]
+			return true, nil
+		case 4: // Ctrl-D ends input on an empty line or deletes the character under the cursor
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return true, io.EOF
+			}
+			if cursor < len(line) {
+				line = append(line[:cursor], line[cursor+1:]...)
+			}
+		case 127, 8: // Backspace
+			if cursor > 0 {
+				line = append(line[:cursor-1], line[cursor:]...)
+				cursor--
+			}
+		case 1: // Ctrl-A
+			cursor = 0
+		case 5: // Ctrl-E
+			cursor = len(line)
+		case 11: // Ctrl-K
+			line = line[:cursor]
+		case 21: // Ctrl-U
+			line = append([]rune{}, line[cursor:]...)
+			cursor = 0
+		case '\t':
+			line, cursor = e.completeLine(line, cursor)
+		case 27:
+			switch e.readEscape() {
+			case "[A":
+				recall(recalled - 1)
+			case "[B":
+				recall(recalled + 1)
+			case "[C":
+				if cursor < len(line) {
+					cursor++
+				}
+			case "[D":
+				if cursor > 0 {
+					cursor--
+				}
+			case "[H", "OH", "[1~":
+				cursor = 0
+			case "[F", "OF", "[4~":
+				cursor = len(line)
+			case "[3~":
+				if cursor < len(line) {
+					line = append(line[:cursor], line[cursor+1:]...)
+				}
+			}
+		default:
+			if r >= ' ' {
+				line = append(line[:cursor], append([]rune{r}, line[cursor:]...)...)
+				cursor++
+			}
+		}
+		redraw()
+		return false, nil
+	}
+
+	e.mutex.Lock()
+	e.redraw = redraw
+	e.mutex.Unlock()
+	defer func() {
+		e.mutex.Lock()
+		e.redraw = nil
+		e.mutex.Unlock()
+	}()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			fmt.Fprint(e.out, "\r\n")
+			return "", err
+		}
+		e.mutex.Lock()
+		done, err := key(r)
+		if done {
+			e.redraw = nil
+		}
+		e.mutex.Unlock()
+		if r == 3 && e.interrupt != nil {
+			e.interrupt()
+		}
+		if done {
+			return string(line), err
+		}
+	}
+}
+
+// readEscape returns the rest of an escape sequence after the escape character
+func (e *lineEditor) readEscape() string {
+	first, err := e.in.ReadByte()
+	if err != nil || (first != '[' && first != 'O') {
+		return ""
+	}
+	seq := []byte{first}
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, b)
+		if b < '0' || b > '9' {
+			return string(seq)
+		}
+	}
+}
+
+// remember adds a line to the history unless it is blank or repeats the last line
+func (e *lineEditor) remember(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// completeLine completes the word before the cursor.  A single completion replaces the word; with
+// several the word is extended to their common prefix or, if it already is, they are listed.
+func (e *lineEditor) completeLine(line []rune, cursor int) ([]rune, int) {
+	if e.complete == nil {
+		return line, cursor
+	}
+	head := string(line[:cursor])
+	start := strings.LastIndex(head, " ") + 1
+	word := head[start:]
+	candidates := e.complete(head)
+	if len(candidates) == 0 {
+		return line, cursor
+	}
+
+	replacement := candidates[0]
+	if len(candidates) == 1 {
+		if !strings.HasSuffix(replacement, "/") {
+			replacement += " "
+		}
+	} else {
+		for _, candidate := range candidates[1:] {
+			for !strings.HasPrefix(candidate, replacement) {
+				replacement = replacement[:len(replacement)-1]
+			}
+		}
+		if len(replacement) <= len(word) {
+			fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+			return line, cursor
+		}
+	}
+	completed := []rune(head[:start] + replacement)
+	return append(completed, line[cursor:]...), len(completed)
+}
+
+// completeConsole returns completions for the last word of a partly typed console command:
+// command names for the first word, and file names, including those under data/, after it
+func completeConsole(head string) []string {
+	fields := strings.Split(head, " ")
+	word := fields[len(fields)-1]
+	candidates := []string{}
+	if len(fields) == 1 {
+		for _, cmd := range consoleCommands {
+			if strings.HasPrefix(cmd.name, strings.ToUpper(word)) {
+				candidates = append(candidates, cmd.name)
+			}
+		}
+		return candidates
+	}
+
+	dirs := []string{filepath.Dir(word)}
+	if !strings.Contains(word, "/") {
+		dirs = append(dirs, "data")
+	}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		prefix := ""
+		if strings.Contains(word, "/") || dir != "." {
+			prefix = strings.TrimSuffix(dir, "/") + "/"
+		}
+		for _, entry := range entries {
+			name := prefix + entry.Name()
+			if entry.IsDir() {
+				name += "/"
+			}
+			if strings.HasPrefix(name, word) || (dir == "data" && strings.HasPrefix(entry.Name(), word)) {
+				candidates = append(candidates, name)
+			}
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
@@ -0,0 +1,170 @@
+/*
+ * Static linter for Intcode program images
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LintIssue is a problem found in a program image without running it
+type LintIssue struct {
+	Address int    // address the issue was found at
+	Error   bool   // whether the program would fail or break the spec, rather than look suspect
+	Reason  string // short description of the issue
+}
+
+// String formats the issue for display
+func (li LintIssue) String() string {
+	severity := "warning"
+	if li.Error {
+		severity = "error"
+	}
+	return fmt.Sprintf("%d: %s: %s", li.Address, severity, li.Reason)
+}
+
+// Lint checks the instructions reachable from address 0 of an image for immediate mode write
+// parameters, invalid opcodes and modes, position mode operands outside the image, and
+// execution running off the end of the image.  Invalid cells that a reachable instruction
+// writes to may be fixed up before they run, so they are only warned about.  It also reports a
+// program with no reachable halt and, when every reachable instruction is valid, cells that
+// are neither reachable code nor referenced as data.  Issues are ordered by address.
+func Lint(image []int) []LintIssue {
+
+	issues := []LintIssue{}
+	report := func(address int, isError bool, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{address, isError, fmt.Sprintf(format, args...)})
+	}
+
+	// Follow every path through the program from address 0
+	code := map[int]bool{}       // cells of reachable instructions
+	referenced := map[int]bool{} // cells reachable instructions use as data
+	visited := map[int]bool{}
+	halts := false
+	computed := false
+	invalid := false
+	undecodable := []int{}   // reachable cells that do not hold a whole valid instruction
+	writers := map[int]int{} // first reachable instruction found writing each cell
+	work := []int{0}
+	for len(work) > 0 {
+		address := work[len(work)-1]
+		work = work[:len(work)-1]
+		if visited[address] {
+			continue
+		}
+		visited[address] = true
+
+		if address >= len(image) {
+			continue // reported by the instruction that got here
+		}
+		si, ok := decodeAt(image, address)
+		if !ok {
+			code[address] = true
+			invalid = true
+			undecodable = append(undecodable, address)
+			continue
+		}
+		for cell := address; cell < si.end(); cell++ {
+			code[cell] = true
+		}
+
+		for param, val := range si.params {
+			if containsInt(si.inst.writes, param) {
+				if _, ok := writers[si.writeTarget(param)]; !ok {
+					writers[si.writeTarget(param)] = address
+				}
+				if si.modes[param] == ImmediateMode {
+					report(address, true, "%s writes through parameter %d in immediate mode", si.inst.mnemonic, param+1)
+				}
+			}
+			if si.modes[param] != PositionMode {
+				continue
+			}
+			if val < 0 || val >= len(image) {
+				report(address, true, "%s parameter %d refers to address %d outside the image", si.inst.mnemonic, param+1, val)
+				continue
+			}
+			referenced[val] = true
+		}
+
+		succs, isComputed := successorsOf(si)
+		halts = halts || si.inst.halts
+		computed = computed || isComputed
+		for _, succ := range succs {
+			if succ < 0 || succ >= len(image) {
+				report(address, true, "execution continues at %d outside the image", succ)
+				continue
+			}
+			work = append(work, succ)
+		}
+	}
+
+	// Cells that are not valid instructions in the image may be made so before they run
+	overwritten := false
+	for _, address := range undecodable {
+		switch writer, ok := writers[address]; {
+		case ok:
+			overwritten = true
+			report(address, false, "not an instruction in the image but overwritten at runtime by %d", writer)
+		case decodeError(image[address]) != nil:
+			report(address, true, "%v", decodeError(image[address]))
+		default:
+			report(address, true, "%s runs past the end of the image", dispatch[image[address]%100].mnemonic)
+		}
+	}
+
+	switch {
+	case !halts && overwritten:
+		report(0, false, "no halt instruction is reachable without running code overwritten at runtime")
+	case !halts:
+		report(0, true, "no halt instruction is reachable")
+	}
+
+	// Jumps to computed addresses may reach code that cannot be found statically, as may the
+	// instructions following an invalid one once it is fixed
+	switch {
+	case computed:
+		report(0, false, "the program jumps to computed addresses so unused cells are not reported")
+	case !invalid:
+		unused := collectRanges(len(image), func(address int) bool {
+			return !code[address] && !referenced[address]
+		})
+		for _, r := range unused {
+			if r.start == r.end {
+				report(r.start, false, "cell %v is neither reachable code nor referenced as data", r)
+			} else {
+				report(r.start, false, "cells %v are neither reachable code nor referenced as data", r)
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Address < issues[j].Address })
+	return issues
+}
+
+// lintTool lints each Intcode image named in the arguments returning a non-zero exit code if
+// any has errors
+func lintTool(args []string) int {
+	if len(args) < 1 {
+		fmt.Println("Usage: lint <image file>...")
+		return 2
+	}
+	status := 0
+	for _, fileName := range args {
+		vm, err := new(VM).Load(fileName)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			status = 1
+			continue
+		}
+		for _, issue := range Lint(vm.memory) {
+			fmt.Printf("%s:%v\n", fileName, issue)
+			if issue.Error {
+				status = 1
+			}
+		}
+	}
+	return status
+}
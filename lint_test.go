@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintOverwrittenCells(t *testing.T) {
+	registerDay5Opcodes(t)
+	for fileName, address := range map[string]int{"data/day05test02.txt": 4, "data/day05.txt": 6} {
+		vm, err := new(VM).Load(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, issue := range Lint(vm.memory) {
+			if issue.Error {
+				t.Errorf("%s: got error %v", fileName, issue)
+			}
+			if issue.Address == address && strings.Contains(issue.Reason, "overwritten at runtime") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: no warning that %d is overwritten at runtime", fileName, address)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Infinite loop detection for the ship's computer
+ */
+
+package main
+
+import (
+	"fmt"
+)
+
+// LoopError reports a run that revisited an identical machine state and so will never halt
+type LoopError struct {
+	Entry       int // address at which the loop is entered, or where a state was revisited if unknown
+	CycleLength int // number of instructions executed per trip around the loop
+	Steps       int // number of instructions executed before the loop was confirmed
+}
+
+// Error formats the loop for display
+func (e *LoopError) Error() string {
+	return fmt.Sprintf("infinite loop detected: ip %d revisited every %d steps (found after %d steps)",
+		e.Entry, e.CycleLength, e.Steps)
+}
+
+// loopState identifies a machine state by its instruction pointer and a hash of its memory.
+// Between input and output the VM is deterministic so revisiting a state means it will cycle.
+type loopState struct {
+	ip   int
+	hash uint64
+}
+
+// loopCandidate is a revisited state that is being confirmed by running one more cycle and
+// comparing the machine state exactly, ruling out hash collisions
+type loopCandidate struct {
+	ip          int   // instruction pointer when the state was revisited
+	memory      []int // copy of memory when the state was revisited
+	cycleLength int   // expected length of the cycle
+	confirmAt   int   // step count at which the state should recur
+}
+
+// loopDetector looks for a revisited state using Brent's algorithm: each state is compared
+// with one saved state, which is replaced by the current state after twice as many steps each
+// time.  This finds a cycle of any length in constant space, within a few cycles of entering it.
+// The state the cycle is entered at is then found by replaying from the first state visited,
+// provided every instruction executed since is built in or declared pure.
+type loopDetector struct {
+	hash      uint64         // incrementally maintained hash of the VM's memory
+	saved     loopState      // state compared with each state visited
+	savedAt   int            // step count at which the saved state was visited, -1 for none
+	power     int            // number of steps after savedAt at which the saved state is replaced
+	candidate *loopCandidate // revisited state awaiting confirmation, if any
+	originIP  int            // instruction pointer when the states being compared began
+	origin    []int          // copy of memory when the states being compared began, nil until visited
+	originAt  int            // step count when the states being compared began
+	replay    bool           // whether every instruction since the origin may safely be executed again
+}
+
+// mixCell scrambles an address and the value stored there into a well distributed hash term
+func mixCell(address, val int) uint64 {
+	z := uint64(address)*0x9e3779b97f4a7c15 ^ uint64(val)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// newLoopDetector returns a detector primed with the hash of the passed memory
+func newLoopDetector(memory []int) *loopDetector {
+	d := &loopDetector{savedAt: -1}
+	for address, val := range memory {
+		d.hash += mixCell(address, val)
+	}
+	return d
+}
+
+// recordWrite updates the memory hash for a write replacing old with val at address
+func (d *loopDetector) recordWrite(address, old, val int) {
+	d.hash += mixCell(address, val) - mixCell(address, old)
+}
+
+// reset forgets the states visited, which the program cannot revisit once it has read input or
+// written output
+func (d *loopDetector) reset() {
+	d.savedAt = -1
+	d.candidate = nil
+	d.origin = nil
+}
+
+// visit is called before each instruction is executed with the number of instructions already
+// executed.  It returns a LoopError once a revisited state has been confirmed as a cycle.
+func (d *loopDetector) visit(ip, steps int, memory []int) *LoopError {
+	if d.origin == nil {
+		d.originIP, d.origin, d.originAt, d.replay = ip, append([]int(nil), memory...), steps, true
+	}
+	if inst, _, ok := decode(memory[ip]); ok && inst.extended && !inst.pure {
+		d.replay = false
+	}
+
+	// Confirm or reject a pending candidate once a full cycle has been executed
+	if d.candidate != nil && steps == d.candidate.confirmAt {
+		c := d.candidate
+		d.candidate = nil
+		if ip == c.ip && equalMemory(memory, c.memory) {
+			entry := ip
+			if d.replay {
+				if address, err := d.entry(c.cycleLength, steps-d.originAt); err == nil {
+					entry = address
+				}
+			}
+			return &LoopError{Entry: entry, CycleLength: c.cycleLength, Steps: steps}
+		}
+	}
+
+	state := loopState{ip: ip, hash: d.hash}
+	if d.savedAt >= 0 && state == d.saved && d.candidate == nil {
+		d.candidate = &loopCandidate{
+			ip:          ip,
+			memory:      append([]int(nil), memory...),
+			cycleLength: steps - d.savedAt,
+			confirmAt:   steps + steps - d.savedAt,
+		}
+	}
+	if d.savedAt < 0 {
+		d.saved, d.savedAt, d.power = state, steps, 1
+	} else if steps-d.savedAt >= d.power {
+		d.saved, d.savedAt, d.power = state, steps, d.power*2
+	}
+	return nil
+}
+
+// entry finds the address at which a cycle of the passed length is entered, using the second
+// phase of Brent's algorithm: two copies of the machine are started from the first state
+// visited, one a full cycle ahead of the other, and stepped together until their states
+// match.  No input or output happens between that state and the cycle so the copies need
+// nothing but memory, and run without observers, devices or the original's hash.  The replay
+// executes no more than the steps the original took to find the cycle, and any fault or a
+// failure to meet within them is returned as an error.
+func (d *loopDetector) entry(cycleLength, steps int) (address int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fault, ok := r.(runFault)
+			if !ok {
+				panic(r)
+			}
+			err = fault.err
+		}
+	}()
+	replay := func() *VM {
+		vm := &VM{memory: append([]int(nil), d.origin...), ip: d.originIP, running: true}
+		vm.loops = newLoopDetector(vm.memory)
+		return vm
+	}
+	step := func(vm *VM) error {
+		next, halted, err := vm.interpret(vm.ip)
+		if err == nil && halted {
+			err = fmt.Errorf("replay halted at %d", vm.ip)
+		}
+		vm.ip = next
+		return err
+	}
+	tortoise, hare := replay(), replay()
+	for i := 0; i < cycleLength; i++ {
+		if err := step(hare); err != nil {
+			return 0, err
+		}
+	}
+	for hareSteps := cycleLength; tortoise.ip != hare.ip || tortoise.loops.hash != hare.loops.hash ||
+		!equalMemory(tortoise.memory, hare.memory); hareSteps++ {
+		if hareSteps >= steps {
+			return 0, fmt.Errorf("replay did not find the cycle within %d steps", steps)
+		}
+		if err := step(tortoise); err != nil {
+			return 0, err
+		}
+		if err := step(hare); err != nil {
+			return 0, err
+		}
+	}
+	return tortoise.ip, nil
+}
+
+// equalMemory reports whether two memory images are identical
+func equalMemory(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetLoopDetection turns detection of infinite loops on or off for subsequent runs.  With it
+// on, a run that revisits an identical state stops with a LoopError.  Detection is suspended
+// while devices are mapped into the VM's address space.
+func (vm *VM) SetLoopDetection(on bool) {
+	vm.detectLoops = on
+}
+
+// LoopDetection reports whether infinite loop detection is turned on
+func (vm *VM) LoopDetection() bool {
+	return vm.detectLoops
+}
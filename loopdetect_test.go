@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// runDetectingLoops runs a program with loop detection on, feeding it the passed input
+func runDetectingLoops(t *testing.T, program string, inputs ...int) error {
+	t.Helper()
+	vm := loadString(t, program)
+	vm.SetLoopDetection(true)
+	vm.SetMaxSteps(100000)
+	vm.QueueInput(inputs...)
+	return vm.Run(false)
+}
+
+func TestLoopDetected(t *testing.T) {
+	registerDay5Opcodes(t)
+	tests := []struct {
+		name        string
+		program     string
+		inputs      []int
+		cycleLength int
+		entry       int
+	}{
+		{"jump to itself", "1105,1,0", nil, 1, 0},
+		{"toggling a cell", "1008,9,0,9,1105,1,0,99,0,0", nil, 4, 0},
+		{"after reading input", "3,9,1005,9,5,1105,1,5,99,0", []int{1}, 1, 5},
+		{"entered after a lead-in", "1101,0,0,20,1101,0,0,21,1105,1,11,1105,1,14,1105,1,8,0,0,0,0,0", nil, 3, 8},
+	}
+	for _, test := range tests {
+		err := runDetectingLoops(t, test.program, test.inputs...)
+		var loop *LoopError
+		if !errors.As(err, &loop) {
+			t.Errorf("%s: got error %v, want a loop", test.name, err)
+			continue
+		}
+		if loop.CycleLength != test.cycleLength {
+			t.Errorf("%s: got a cycle of %d steps, want %d", test.name, loop.CycleLength, test.cycleLength)
+		}
+		if loop.Entry != test.entry {
+			t.Errorf("%s: got a loop entered at %d, want %d", test.name, loop.Entry, test.entry)
+		}
+	}
+}
+
+func TestLoopEndedByInput(t *testing.T) {
+	registerDay5Opcodes(t)
+	if err := runDetectingLoops(t, "3,7,1005,7,0,99,0,0", 5, 5, 5, 5, 0); err != nil {
+		t.Errorf("reading until a zero is read: %v", err)
+	}
+	if err := runDetectingLoops(t, "1001,13,1,13,1007,13,5000,14,1005,14,0,99,0,0,0"); err != nil {
+		t.Errorf("counting to 5000: %v", err)
+	}
+}
+
+func TestLoopEntryNotReplayedThroughImpureOpcodes(t *testing.T) {
+	registerDay5Opcodes(t)
+	executed := 0
+	op := Opcode{Number: 50, Mnemonic: "DEBUG", Exec: func(vm *VM, a *Args) error {
+		executed++
+		return nil
+	}}
+	if err := RegisterOpcode(op); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { UnregisterOpcode(50) })
+
+	// A lead-in jump then a loop of the debug instruction and a jump back to it
+	vm := loadString(t, "1105,1,3,50,1105,1,3")
+	vm.SetLoopDetection(true)
+	vm.SetMaxSteps(1000)
+	profiler := vm.EnableProfiler()
+	err := vm.Run(false)
+	var loop *LoopError
+	if !errors.As(err, &loop) {
+		t.Fatalf("got error %v, want a loop", err)
+	}
+	if want := profiler.ExecCount(3); executed != want {
+		t.Errorf("DEBUG ran %d times for %d executions by the program", executed, want)
+	}
+	if loop.Entry != 3 && loop.Entry != 4 {
+		t.Errorf("got a loop entered at %d, want an address in the loop", loop.Entry)
+	}
+}
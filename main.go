@@ -6,8 +6,17 @@ package main
 
 import (
 	"fmt"
-	"strconv"
+	"os"
+	"os/signal"
 	"strings"
+	"time"
+)
+
+const (
+	// consoleMaxSteps is the default step limit for programs run from the console
+	consoleMaxSteps = 10000000
+	// consoleTimeout is the default time limit for programs run from the console
+	consoleTimeout = 30 * time.Second
 )
 
 func tryProblem(name string, expected int, actual int) {
@@ -23,103 +32,85 @@ func runProblems() {
 	tryProblem("01-B", problem01B("./data/day01.txt"), 4943923)
 	tryProblem("02-A", problem02A("./data/day02.txt"), 4690667)
 	tryProblem("02-B", problem02B("./data/day02.txt", 19690720), 6255)
+	tryProblem("02-B (brute force)", problem02BBruteForce("./data/day02.txt", 19690720), 6255)
 	tryProblem("03-A", problem03A("./data/day03.txt"), 227)
 	tryProblem("03-B", problem03B("./data/day03.txt"), 20286)
 	tryProblem("04-A", problem04A(171309, 643603), 1625)
 	tryProblem("04-B", problem04B(171309, 643603), 1111)
 }
 
-func loadConsole() {
-
-	var vm VM
-	var err error
+// loadConsole runs the Intcode console until the user quits, returning true if the input ended
+// instead
+func loadConsole() bool {
+	c := newConsole()
+	stdin.complete = completeConsole
+	stdin.interrupt = c.interrupt
+	defer func() { stdin.complete, stdin.interrupt = nil, nil }()
 
-consoleloop:
-	for {
-		val := prompt("", "$")
-		tokens := strings.Split(val, " ")
-		if len(tokens) < 1 || len(tokens[0]) < 2 {
-			continue
+	// Ctrl-C pauses a running program rather than ending the process
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer func() {
+		signal.Stop(interrupts)
+		close(interrupts)
+	}()
+	go func() {
+		for range interrupts {
+			c.interrupt()
 		}
-		switch strings.ToUpper(tokens[0])[:2] {
-		case "LO":
-			if len(tokens) < 2 {
-				fmt.Println("Please provide the name of a file to load")
-				break
-			}
-			vm, err = vm.Load(tokens[1])
-			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-			}
-			fmt.Printf("%s loaded\n", tokens[1])
-		case "WR":
-			if len(tokens) < 3 {
-				fmt.Println("Please provide an address and data")
-				break
-			}
-			if vm == nil {
-				fmt.Println("Please load the VM first using 'LOAD <file name>'")
-				break
-			}
-			addr, err := strconv.Atoi(tokens[1])
-			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-			}
-			val, err := strconv.Atoi(tokens[2])
-			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-			}
-			vm.ModeWrite(addr, val, ImmediateMode)
-			fmt.Printf("%d written to %d\n", val, addr)
-		case "RE":
-			if len(tokens) < 2 {
-				fmt.Println("Please provide an address to read")
-				break
-			}
-			if vm == nil {
-				fmt.Println("Please load the VM first using 'LOAD <file name>'")
-				break
-			}
-			addr, err := strconv.Atoi(tokens[1])
-			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-			}
-			val := vm.ModeRead(addr, ImmediateMode)
-			fmt.Printf("%d contains %d\n", addr, val)
-		case "RU":
-			if vm == nil {
-				fmt.Println("Please load the VM first using 'LOAD <file name>'")
-				break
-			}
-			err := vm.Run(false)
-			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-			}
-		case "HE":
-			fmt.Println("Command options:")
-			fmt.Println("\tLOAD <file name>")
-			fmt.Println("\tWRITE <address> <value>")
-			fmt.Println("\tREAD <address>")
-			fmt.Println("\tRUN")
-			fmt.Println("\tQUIT")
-		case "QU":
-			break consoleloop
-		default:
-			fmt.Printf("Unrecognized command '%s'--try 'HELP'\n", val)
+	}()
+	for !c.quit {
+		line, err := prompt("", c.session.name+"$")
+		if err != nil {
+			return true
 		}
+		c.execute(line)
+	}
+	return false
+}
+
+// runTool runs the named command line tool with the passed arguments and returns the process
+// exit code
+func runTool(name string, args []string) int {
+	switch strings.ToLower(name) {
+	case "decompile":
+		return decompileTool(args)
+	case "lint":
+		return lintTool(args)
+	case "search":
+		return searchTool(args)
+	case "serve":
+		return serveTool(args)
+	case "snapshot":
+		return snapshotTool(args)
+	case "transpile":
+		return transpileTool(args)
+	default:
+		fmt.Printf("Unrecognized tool '%s'--try 'decompile', 'lint', 'search', 'serve', 'snapshot', or 'transpile'\n", name)
+		return 2
 	}
 }
 
 func main() {
 
+	// Command line tools bypass the interactive menu
+	if len(os.Args) > 1 {
+		os.Exit(runTool(os.Args[1], os.Args[2:]))
+	}
+
 mainloop:
 	for {
-		val := prompt("Select an option--(R)un Problems, Intcode (C)onsole, (Q)uit:", ">")
+		val, err := prompt("Select an option--(R)un Problems, Intcode (C)onsole, (Q)uit:", ">")
+		if err != nil {
+			break
+		}
 		switch strings.ToUpper(val) {
 		case "R":
 			runProblems()
 		case "C":
-			loadConsole()
+			if loadConsole() {
+				break mainloop
+			}
 		case "Q":
 			break mainloop
 		default:
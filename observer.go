@@ -0,0 +1,121 @@
+/*
+ * Observer hooks for the ship's computer
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Step describes an instruction the VM is about to execute
+type Step struct {
+	IP    int          // address of the instruction
+	inst  *instruction // instruction decoded at the address
+	modes paramModes   // modes of the instruction's parameters
+}
+
+// Opcode returns the opcode of the instruction
+func (s Step) Opcode() int {
+	return s.inst.opcode
+}
+
+// Mnemonic returns the name of the instruction
+func (s Step) Mnemonic() string {
+	return s.inst.mnemonic
+}
+
+// Params returns the number of parameters the instruction takes
+func (s Step) Params() int {
+	return s.inst.width - 1
+}
+
+// Mode returns the mode of the parameter with the passed index
+func (s Step) Mode(param int) ParamMode {
+	return s.modes[param]
+}
+
+// Observer is notified of what a VM does so that tools can follow a program without changes
+// to the VM.  Observers are called synchronously and must not modify the VM.
+type Observer interface {
+	// OnStep is called before each instruction is executed
+	OnStep(vm *VM, step Step)
+	// OnRead is called when a value is read from memory as data
+	OnRead(vm *VM, address, val int)
+	// OnWrite is called before val is written over old at the passed address, old being zero
+	// for addresses mapped to a device
+	OnWrite(vm *VM, address, old, val int)
+	// OnInput is called when the program takes a value from its input queue
+	OnInput(vm *VM, val int)
+	// OnOutput is called when the program writes a value to its output
+	OnOutput(vm *VM, val int)
+	// OnHalt is called when a run stops, with the error that stopped it or nil if the program
+	// reached a halt instruction
+	OnHalt(vm *VM, err error)
+}
+
+// NopObserver ignores every notification.  Embed it to implement only the hooks needed.
+type NopObserver struct{}
+
+// OnStep does nothing
+func (NopObserver) OnStep(vm *VM, step Step) {}
+
+// OnRead does nothing
+func (NopObserver) OnRead(vm *VM, address, val int) {}
+
+// OnWrite does nothing
+func (NopObserver) OnWrite(vm *VM, address, old, val int) {}
+
+// OnInput does nothing
+func (NopObserver) OnInput(vm *VM, val int) {}
+
+// OnOutput does nothing
+func (NopObserver) OnOutput(vm *VM, val int) {}
+
+// OnHalt does nothing
+func (NopObserver) OnHalt(vm *VM, err error) {}
+
+// AddObserver attaches an observer to the VM.  Observers are notified in the order added.
+func (vm *VM) AddObserver(o Observer) {
+	vm.observers = append(vm.observers, o)
+}
+
+// RemoveObserver detaches an observer from the VM
+func (vm *VM) RemoveObserver(o Observer) {
+	for i, attached := range vm.observers {
+		if attached == o {
+			vm.observers = append(vm.observers[:i:i], vm.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// observeStep notifies the observers of an instruction about to be executed
+func (vm *VM) observeStep(ip int, inst *instruction, modes paramModes) {
+	step := Step{IP: ip, inst: inst, modes: modes}
+	for _, o := range vm.observers {
+		o.OnStep(vm, step)
+	}
+}
+
+// StepPrinter is an observer writing each instruction, with the values of its parameters, as
+// it is about to be executed
+type StepPrinter struct {
+	NopObserver
+	w io.Writer
+}
+
+// NewStepPrinter returns an observer printing instructions to w
+func NewStepPrinter(w io.Writer) *StepPrinter {
+	return &StepPrinter{w: w}
+}
+
+// OnStep prints the instruction
+func (p *StepPrinter) OnStep(vm *VM, step Step) {
+	line := fmt.Sprintf("%4d:\t%s", step.IP, step.Mnemonic())
+	for param := 0; param < step.Params(); param++ {
+		line += "\t" + vm.fmtParam(step.IP+1+param, step.Mode(param))
+	}
+	fmt.Fprintln(p.w, line)
+}
@@ -0,0 +1,170 @@
+/*
+ * Patches assembled into program images from the console
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// patch is a change to a program image: values written over memory starting at an address
+type patch struct {
+	address int
+	values  []int
+	text    string // command the patch was made with, as saved in patch files
+}
+
+// assemble encodes an instruction written in the syntax of the disassembler, such as
+// 'ADD $1 [9] [3]', where '$' marks an immediate parameter and a bare or bracketed number a
+// position parameter
+func assemble(text string) ([]int, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no instruction to assemble")
+	}
+	var inst *instruction
+	for _, candidate := range dispatch {
+		if candidate != nil && strings.EqualFold(candidate.mnemonic, fields[0]) {
+			inst = candidate
+			break
+		}
+	}
+	if inst == nil {
+		return nil, fmt.Errorf("unknown instruction '%s'", fields[0])
+	}
+	params := fields[1:]
+	if len(params) != inst.width-1 {
+		return nil, fmt.Errorf("%s takes %d parameters but %d were given", inst.mnemonic, inst.width-1, len(params))
+	}
+
+	code := []int{inst.opcode}
+	scale := 100
+	for i, param := range params {
+		mode := PositionMode
+		switch {
+		case strings.HasPrefix(param, "$"):
+			mode = ImmediateMode
+			param = param[1:]
+		case strings.HasPrefix(param, "[") && strings.HasSuffix(param, "]"):
+			param = param[1 : len(param)-1]
+		}
+		val, err := strconv.Atoi(param)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %d of %s: %v", i+1, inst.mnemonic, err)
+		}
+		code[0] += int(mode) * scale
+		scale *= 10
+		code = append(code, val)
+	}
+	return code, nil
+}
+
+// parsePatch parses a patch command for a memory of the passed size: 'PATCH <address>
+// <instruction>' assembling an instruction at an address, or 'FILL <start> <end> <value>'
+// storing a value in each address of an inclusive range
+func parsePatch(text string, size int) (patch, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return patch{}, fmt.Errorf("empty patch")
+	}
+	usage := fmt.Errorf("please use 'PATCH <address> <instruction>' or 'FILL <start> <end> <value>'")
+	switch strings.ToUpper(fields[0]) {
+	case "PATCH":
+		if len(fields) < 3 {
+			return patch{}, usage
+		}
+		address, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return patch{}, err
+		}
+		code, err := assemble(strings.Join(fields[2:], " "))
+		if err != nil {
+			return patch{}, err
+		}
+		if address < 0 || address > size-len(code) {
+			return patch{}, fmt.Errorf("the instruction at %d is outside memory of size %d", address, size)
+		}
+		return patch{address, code, strings.Join(fields, " ")}, nil
+	case "FILL":
+		if len(fields) != 4 {
+			return patch{}, usage
+		}
+		vals := make([]int, 3)
+		for i, field := range fields[1:] {
+			val, err := strconv.Atoi(field)
+			if err != nil {
+				return patch{}, err
+			}
+			vals[i] = val
+		}
+		if vals[1] < vals[0] {
+			return patch{}, fmt.Errorf("the range %d-%d is empty", vals[0], vals[1])
+		}
+		if vals[0] < 0 || vals[1] >= size {
+			return patch{}, fmt.Errorf("the range %d-%d is outside memory of size %d", vals[0], vals[1], size)
+		}
+		values := make([]int, vals[1]-vals[0]+1)
+		for i := range values {
+			values[i] = vals[2]
+		}
+		return patch{vals[0], values, strings.Join(fields, " ")}, nil
+	default:
+		return patch{}, usage
+	}
+}
+
+// apply writes the patch into the VM's memory without devices or observers seeing the writes
+func (p patch) apply(vm *VM) error {
+	if p.address < 0 || p.address > vm.Size()-len(p.values) {
+		return fmt.Errorf("'%s' writes addresses %d-%d outside memory of size %d",
+			p.text, p.address, p.address+len(p.values)-1, vm.Size())
+	}
+	for i, val := range p.values {
+		if err := vm.Poke(p.address+i, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPatches reads a patch file for a memory of the passed size.  The file holds one patch
+// command per line with blank lines and lines starting with '#' ignored.
+func loadPatches(fileName string, size int) ([]patch, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	patches := []patch{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		p, err := parsePatch(text, size)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fileName, line, err)
+		}
+		patches = append(patches, p)
+	}
+	return patches, scanner.Err()
+}
+
+// savePatches writes patches to a patch file in the order they were made
+func savePatches(fileName string, patches []patch) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	for _, p := range patches {
+		fmt.Fprintln(file, p.text)
+	}
+	return file.Close()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFill(t *testing.T) {
+	p, err := parsePatch("FILL 1 3 7", 5)
+	if err != nil || p.address != 1 || !reflect.DeepEqual(p.values, []int{7, 7, 7}) {
+		t.Errorf("got %+v, %v", p, err)
+	}
+	for _, text := range []string{"FILL 0 9999999999 1", "FILL -1 2 0", "FILL 2 5 0", "FILL 3 1 0"} {
+		if _, err := parsePatch(text, 5); err == nil {
+			t.Errorf("%s: got no error for a memory of size 5", text)
+		}
+	}
+}
+
+func TestParsePatchRange(t *testing.T) {
+	for _, text := range []string{"PATCH 9223372036854775806 ADD 1 2 3", "PATCH -1 HLT", "PATCH 2 ADD 1 2 3"} {
+		if _, err := parsePatch(text, 5); err == nil {
+			t.Errorf("%s: got no error for a memory of size 5", text)
+		}
+	}
+	vm := loadString(t, "1,0,0,0,99")
+	p := patch{9223372036854775806, []int{1, 1, 2, 3}, "PATCH 9223372036854775806 ADD 1 2 3"}
+	if err := p.apply(vm); err == nil {
+		t.Error("got no error applying a patch past the end of memory")
+	}
+}
+
+func TestPatchesBypassDevicesAndObservers(t *testing.T) {
+	vm := loadString(t, "1,0,0,0,99,0,0")
+	profiler := vm.EnableProfiler()
+	var device strings.Builder
+	if err := vm.MapDevice(5, NewCharDevice(strings.NewReader(""), &device)); err != nil {
+		t.Fatal(err)
+	}
+	p, err := parsePatch("FILL 0 6 65", vm.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.apply(vm); err != nil {
+		t.Fatal(err)
+	}
+	if device.Len() != 0 || profiler.usageOf(0) != 0 || vm.memory[6] != 65 {
+		t.Errorf("the device printed %q, the profiler saw %v, and memory holds %v", device.String(), profiler.usageOf(0), vm.memory)
+	}
+}
+
+func TestLoadBadPatchesKeepsVM(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		fileName := filepath.Join(dir, name)
+		if err := os.WriteFile(fileName, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fileName
+	}
+	first := write("first.txt", "1,0,0,0,99")
+	second := write("second.txt", "2,0,0,0,99,0")
+	patches := write("patches.txt", "FILL 5 5 1\nPATCH 4 ADD $1 $1 [0]\n")
+
+	s := newConsoleSession("test")
+	if err := s.load(first, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.load(second, patches, false); err == nil {
+		t.Fatal("got no error applying a patch past the end of memory")
+	}
+	if s.file != first || !reflect.DeepEqual(s.vm.memory, []int{1, 0, 0, 0, 99}) {
+		t.Errorf("after a failed load the session holds %s with memory %v", s.file, s.vm.memory)
+	}
+}
@@ -42,32 +42,75 @@
 package main
 
 import (
+	"context"
 	"log"
 )
 
+// problem02B solves for the noun and verb symbolically: addresses 1 and 2 are treated as
+// variables so that running the program leaves address 0 holding a polynomial in them which
+// is solved for the target.  Should the program use the noun or verb in a way that cannot be
+// evaluated symbolically the search falls back to brute force.
 func problem02B(fileName string, target int) int {
 
-	for noun := 0; noun < 100; noun++ {
-		for verb := 0; verb < 100; verb++ {
+	vm, err := new(VM).Load(fileName)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			vm, err := new(VM).Load(fileName)
-			if err != nil {
-				log.Fatal(err)
-			}
+	output, err := EvaluateSymbolic(vm.memory, map[int]string{1: "noun", 2: "verb"}, 0, consoleMaxSteps)
+	if err != nil {
+		return problem02BBruteForce(fileName, target)
+	}
+	solutions, err := output.Solve(target, map[string]VarRange{
+		"noun": {0, 99},
+		"verb": {0, 99},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(solutions) == 0 {
+		return 0
+	}
 
-			vm.ModeWrite(1, noun, ImmediateMode)
-			vm.ModeWrite(2, verb, ImmediateMode)
+	// Report the smallest answer as brute force would find it first
+	best := 100*solutions[0]["noun"] + solutions[0]["verb"]
+	for _, solution := range solutions[1:] {
+		if answer := 100*solution["noun"] + solution["verb"]; answer < best {
+			best = answer
+		}
+	}
+	return best
+}
 
-			err = vm.Run(false)
-			if err != nil {
-				log.Fatal(err)
-			}
+// problem02BBruteForce tries every noun and verb in turn and is kept to cross-check the
+// symbolic solution
+func problem02BBruteForce(fileName string, target int) int {
 
-			if vm.ModeRead(0, ImmediateMode) == target {
-				return (100*noun + verb)
-			}
-		}
+	vm, err := new(VM).Load(fileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Candidates that never terminate cannot be the answer so skip them
+	vm.SetLoopDetection(true)
+
+	search := Search{
+		Program: vm,
+		Params: []SearchParam{
+			{Address: 1, Min: 0, Max: 99}, // noun
+			{Address: 2, Min: 0, Max: 99}, // verb
+		},
+		Predicate: func(vm *VM) bool {
+			return vm.ModeRead(0, ImmediateMode) == target
+		},
+	}
+	matches, err := search.Run(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(matches) == 0 {
+		return 0
 	}
 
-	return 0
+	return (100*matches[0].Values[0] + matches[0].Values[1])
 }
@@ -0,0 +1,203 @@
+/*
+ * Execution profiler for the ship's computer
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// cellUsage is a set of flags recording how a memory cell has been used
+type cellUsage int
+
+const (
+	// cellExecuted the cell was part of an executed instruction (opcode or parameter)
+	cellExecuted cellUsage = 1 << iota
+	// cellRead the cell was read as data
+	cellRead
+	// cellWritten the cell was written as data
+	cellWritten
+)
+
+// Profiler counts executions per address and per opcode and records which memory cells were
+// executed, read, or written while a VM ran
+type Profiler struct {
+	NopObserver
+	steps        int               // total number of instructions executed
+	addrCounts   []int             // number of times the instruction at each address was executed
+	addrOpcodes  []int             // opcode most recently executed at each address
+	opcodeCounts map[int]int       // number of times each opcode was executed
+	usage        []cellUsage       // how each memory cell has been used
+	devices      map[int]cellUsage // how each device address outside memory has been used
+}
+
+// newProfiler returns an empty profiler
+func newProfiler() *Profiler {
+	return &Profiler{opcodeCounts: map[int]int{}, devices: map[int]cellUsage{}}
+}
+
+// grow makes sure the profiler can track the cell at the passed address
+func (p *Profiler) grow(address int) {
+	for len(p.usage) <= address {
+		p.usage = append(p.usage, 0)
+		p.addrCounts = append(p.addrCounts, 0)
+		p.addrOpcodes = append(p.addrOpcodes, 0)
+	}
+}
+
+// OnStep records the execution of an instruction
+func (p *Profiler) OnStep(vm *VM, step Step) {
+	address, opcode, width := step.IP, step.Opcode(), step.Params()+1
+	p.grow(address + width - 1)
+	p.steps++
+	p.addrCounts[address]++
+	p.addrOpcodes[address] = opcode
+	p.opcodeCounts[opcode]++
+	for offset := 0; offset < width; offset++ {
+		p.usage[address+offset] |= cellExecuted
+	}
+}
+
+// mark records a data access to the passed address.  Devices may be mapped far beyond memory
+// so their addresses are kept apart rather than growing the counters to reach them.
+func (p *Profiler) mark(vm *VM, address int, usage cellUsage) {
+	if address < 0 || address >= vm.Size() {
+		p.devices[address] |= usage
+		return
+	}
+	p.grow(address)
+	p.usage[address] |= usage
+}
+
+// OnRead records a data read from the passed address
+func (p *Profiler) OnRead(vm *VM, address, val int) {
+	p.mark(vm, address, cellRead)
+}
+
+// OnWrite records a data write to the passed address
+func (p *Profiler) OnWrite(vm *VM, address, old, val int) {
+	p.mark(vm, address, cellWritten)
+}
+
+// Steps returns the total number of instructions executed while profiling
+func (p *Profiler) Steps() int {
+	return p.steps
+}
+
+// ExecCount returns the number of times the instruction at the passed address was executed
+func (p *Profiler) ExecCount(address int) int {
+	if address < 0 || address >= len(p.addrCounts) {
+		return 0
+	}
+	return p.addrCounts[address]
+}
+
+// OpcodeCount returns the number of times the passed opcode was executed
+func (p *Profiler) OpcodeCount(opcode int) int {
+	return p.opcodeCounts[opcode]
+}
+
+// usageOf returns the recorded usage of the cell at the passed address
+func (p *Profiler) usageOf(address int) cellUsage {
+	if address < 0 || address >= len(p.usage) {
+		return p.devices[address]
+	}
+	return p.usage[address]
+}
+
+// addressRange is an inclusive range of memory addresses
+type addressRange struct {
+	start int
+	end   int
+}
+
+// String formats the range for display
+func (r addressRange) String() string {
+	if r.start == r.end {
+		return fmt.Sprintf("%d", r.start)
+	}
+	return fmt.Sprintf("%d-%d", r.start, r.end)
+}
+
+// collectRanges returns the ranges of addresses below size for which the passed test is true
+func collectRanges(size int, test func(address int) bool) []addressRange {
+	ranges := []addressRange{}
+	for address := 0; address < size; address++ {
+		if !test(address) {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].end == address-1 {
+			ranges[n-1].end = address
+		} else {
+			ranges = append(ranges, addressRange{address, address})
+		}
+	}
+	return ranges
+}
+
+// Report writes a summary of the profile to w listing the opcode counts, the top hottest
+// instructions, the cells of the VM's memory that were never executed nor used as data, and
+// the cells that were only ever used as data
+func (p *Profiler) Report(w io.Writer, vm *VM, top int) {
+
+	fmt.Fprintf(w, "Instructions executed: %d\n", p.steps)
+
+	// Opcode counts in opcode order
+	fmt.Fprintln(w, "Opcode counts:")
+	opcodes := []int{}
+	for opcode := range p.opcodeCounts {
+		opcodes = append(opcodes, opcode)
+	}
+	sort.Ints(opcodes)
+	for _, opcode := range opcodes {
+		fmt.Fprintf(w, "\t%s (%d)\t%d\n", instructions[opcode].mnemonic, opcode, p.opcodeCounts[opcode])
+	}
+
+	// Hottest instructions, ties broken by address
+	hot := []int{}
+	for address, count := range p.addrCounts {
+		if count > 0 {
+			hot = append(hot, address)
+		}
+	}
+	sort.SliceStable(hot, func(i, j int) bool {
+		return p.addrCounts[hot[i]] > p.addrCounts[hot[j]]
+	})
+	if top > 0 && len(hot) > top {
+		hot = hot[:top]
+	}
+	fmt.Fprintf(w, "Top %d hot instructions:\n", len(hot))
+	for _, address := range hot {
+		mnemonic := instructions[p.addrOpcodes[address]].mnemonic
+		fmt.Fprintf(w, "\t%4d:\t%s\t%d\n", address, mnemonic, p.addrCounts[address])
+	}
+
+	// Cells never touched at all are candidates for dead code
+	untouched := collectRanges(vm.Size(), func(address int) bool {
+		return p.usageOf(address) == 0
+	})
+	fmt.Fprintln(w, "Never executed or accessed:")
+	for _, r := range untouched {
+		fmt.Fprintf(w, "\t%v\n", r)
+	}
+
+	// Cells used only as data
+	fmt.Fprintln(w, "Data cells:")
+	for address := 0; address < vm.Size(); address++ {
+		usage := p.usageOf(address)
+		if usage == 0 || usage&cellExecuted != 0 {
+			continue
+		}
+		access := ""
+		if usage&cellRead != 0 {
+			access += "R"
+		}
+		if usage&cellWritten != 0 {
+			access += "W"
+		}
+		fmt.Fprintf(w, "\t%4d:\t%-2s\t%d\n", address, access, vm.memory[address])
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestProfilerFarDevice(t *testing.T) {
+	const device = 1 << 40
+	vm := loadString(t, "1,1099511627776,0,0,99")
+	if err := vm.MapDevice(device, NewRandomDevice(1)); err != nil {
+		t.Fatal(err)
+	}
+	p := vm.EnableProfiler()
+	if err := vm.Run(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.usage) > vm.Size() {
+		t.Errorf("profiler tracks %d cells for a memory of %d", len(p.usage), vm.Size())
+	}
+	if p.usageOf(device) != cellRead || p.usageOf(0) != cellExecuted|cellRead|cellWritten {
+		t.Errorf("got usage %v of the device and %v of address 0", p.usageOf(device), p.usageOf(0))
+	}
+}
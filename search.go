@@ -0,0 +1,385 @@
+/*
+ * Parameter search over Intcode programs
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SearchParam is a value varied by a search, stored either at a memory address or at a
+// position in the input queue before each candidate runs
+type SearchParam struct {
+	Address  int  // memory address the value is stored at, unless Input is set
+	Input    bool // whether the value is queued as input rather than stored in memory
+	Position int  // position in the input queue the value takes when Input is set
+	Min      int  // smallest value tried
+	Max      int  // largest value tried
+}
+
+// String formats the parameter for display
+func (p SearchParam) String() string {
+	if p.Input {
+		return fmt.Sprintf("input %d", p.Position)
+	}
+	return fmt.Sprintf("address %d", p.Address)
+}
+
+// SearchMatch is an assignment of values to a search's parameters satisfying its predicate
+type SearchMatch struct {
+	Values  []int // value of each parameter in the order the parameters were given
+	Outputs []int // values written by the program for the assignment
+	index   int   // position of the assignment in the order candidates are enumerated
+}
+
+// Search describes a search for the values of a program's parameters satisfying a predicate.
+// Candidates are enumerated with the first parameter varying slowest.
+type Search struct {
+	Program   *VM                            // VM holding the program, input, and settings each candidate starts from
+	Params    []SearchParam                  // values to vary
+	Predicate func(vm *VM) bool              // tests the VM of a candidate that halted
+	All       bool                           // whether to find every match rather than the first
+	Workers   int                            // number of candidates run in parallel, the number of CPUs if zero
+	Progress  func(done, total, matches int) // called periodically while searching, if set
+}
+
+// progressInterval is how often a search reports its progress
+const progressInterval = time.Second
+
+// candidates returns the number of assignments the search will try
+func (s *Search) candidates() (int, error) {
+	total := 1
+	maxInt := int(^uint(0) >> 1)
+	for _, p := range s.Params {
+		if p.Max < p.Min {
+			return 0, fmt.Errorf("%v has an empty range %d..%d", p, p.Min, p.Max)
+		}
+		if p.Max-p.Min < 0 || p.Max-p.Min == maxInt { // the span overflows
+			return 0, fmt.Errorf("too many candidates to search")
+		}
+		span := p.Max - p.Min + 1
+		if total > maxInt/span {
+			return 0, fmt.Errorf("too many candidates to search")
+		}
+		total *= span
+	}
+	return total, nil
+}
+
+// assignment returns the values of the candidate at the passed index
+func (s *Search) assignment(index int) []int {
+	values := make([]int, len(s.Params))
+	for i := len(s.Params) - 1; i >= 0; i-- {
+		span := s.Params[i].Max - s.Params[i].Min + 1
+		values[i] = s.Params[i].Min + index%span
+		index /= span
+	}
+	return values
+}
+
+// prepare sets up a clone of the program VM to run the passed assignment
+func (s *Search) prepare(vm *VM, values []int) {
+	vm.restore(s.Program.memory)
+	vm.inputs = append(vm.inputs[:0], s.Program.inputs...)
+	vm.outputs = nil
+	for i, p := range s.Params {
+		if !p.Input {
+			vm.ModeWrite(p.Address, values[i], ImmediateMode)
+			continue
+		}
+		for len(vm.inputs) <= p.Position {
+			vm.inputs = append(vm.inputs, 0)
+		}
+		vm.inputs[p.Position] = values[i]
+	}
+}
+
+// Run searches for matching assignments across a pool of workers, each running candidates on
+// its own clone of the program VM.  Candidates whose runs fail, including ones that never
+// halt when the program VM limits steps or detects loops, do not match.  Matches are returned
+// in enumeration order; if the context is done the matches found so far are returned along
+// with the context's error.
+func (s *Search) Run(ctx context.Context) ([]SearchMatch, error) {
+
+	total, err := s.candidates()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range s.Params {
+		if !p.Input && (p.Address < 0 || p.Address >= s.Program.Size()) {
+			return nil, fmt.Errorf("%v is outside memory", p)
+		}
+		if p.Input && p.Position < 0 {
+			return nil, fmt.Errorf("%v is not a position in the input queue", p)
+		}
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var next int64 = -1        // index of the last candidate handed to a worker
+	var done int64             // number of candidates finished
+	first := int64(total)      // index of the earliest match when looking for the first
+	var mutex sync.Mutex       // guards matches
+	matches := []SearchMatch{} // matches found so far
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vm := s.Program.Clone()
+			for ctx.Err() == nil {
+				index := atomic.AddInt64(&next, 1)
+				if index >= int64(total) || (!s.All && index >= atomic.LoadInt64(&first)) {
+					return
+				}
+				values := s.assignment(int(index))
+				s.prepare(vm, values)
+				if vm.RunContext(ctx, false) == nil && s.Predicate(vm) {
+					mutex.Lock()
+					matches = append(matches, SearchMatch{
+						Values:  values,
+						Outputs: append([]int(nil), vm.outputs...),
+						index:   int(index),
+					})
+					mutex.Unlock()
+					for earliest := atomic.LoadInt64(&first); index < earliest; earliest = atomic.LoadInt64(&first) {
+						if atomic.CompareAndSwapInt64(&first, earliest, index) {
+							break
+						}
+					}
+				}
+				atomic.AddInt64(&done, 1)
+			}
+		}()
+	}
+
+	// Report progress until the workers finish
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	if s.Progress != nil {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+	progressLoop:
+		for {
+			select {
+			case <-ticker.C:
+				mutex.Lock()
+				found := len(matches)
+				mutex.Unlock()
+				s.Progress(int(atomic.LoadInt64(&done)), total, found)
+			case <-finished:
+				break progressLoop
+			}
+		}
+	}
+	<-finished
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].index < matches[j].index })
+	if !s.All && len(matches) > 1 {
+		matches = matches[:1]
+	}
+	return matches, ctx.Err()
+}
+
+// predicateCondition matches a single condition of a search predicate such as 'mem[0]==5' or
+// 'out[-1]>=0'
+var predicateCondition = regexp.MustCompile(`^\s*(mem|out)\[\s*(-?\d+)\s*\]\s*(==|!=|<=|>=|<|>)\s*(-?\d+)\s*$`)
+
+// parsePredicate builds a predicate from conditions joined by '&&' each comparing a memory
+// cell, mem[address], or an output, out[index] with negative indexes counting from the last
+// output, to a value
+func parsePredicate(expr string) (func(vm *VM) bool, error) {
+	type condition struct {
+		memory bool
+		index  int
+		op     string
+		val    int
+	}
+	conditions := []condition{}
+	for _, text := range strings.Split(expr, "&&") {
+		parts := predicateCondition.FindStringSubmatch(text)
+		if parts == nil {
+			return nil, fmt.Errorf("cannot understand the condition '%s'", strings.TrimSpace(text))
+		}
+		index, _ := strconv.Atoi(parts[2])
+		val, _ := strconv.Atoi(parts[4])
+		conditions = append(conditions, condition{parts[1] == "mem", index, parts[3], val})
+	}
+
+	return func(vm *VM) bool {
+		for _, c := range conditions {
+			var actual int
+			if c.memory {
+				if c.index < 0 || c.index >= vm.Size() {
+					return false
+				}
+				actual = vm.memory[c.index]
+			} else {
+				index := c.index
+				if index < 0 {
+					index += len(vm.outputs)
+				}
+				if index < 0 || index >= len(vm.outputs) {
+					return false
+				}
+				actual = vm.outputs[index]
+			}
+			var holds bool
+			switch c.op {
+			case "==":
+				holds = actual == c.val
+			case "!=":
+				holds = actual != c.val
+			case "<":
+				holds = actual < c.val
+			case "<=":
+				holds = actual <= c.val
+			case ">":
+				holds = actual > c.val
+			case ">=":
+				holds = actual >= c.val
+			}
+			if !holds {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// parseSearchRange parses '<n>=<min>..<max>' returning the number and the range
+func parseSearchRange(text string) (int, int, int, error) {
+	pair := strings.SplitN(text, "=", 2)
+	bounds := []string{}
+	if len(pair) == 2 {
+		bounds = strings.SplitN(pair[1], "..", 2)
+	}
+	if len(bounds) != 2 {
+		return 0, 0, 0, fmt.Errorf("'%s' is not of the form <n>=<min>..<max>", text)
+	}
+	n, err := strconv.Atoi(pair[0])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	min, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	max, err := strconv.Atoi(bounds[1])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return n, min, max, nil
+}
+
+// searchTool searches a program for parameter values satisfying a predicate.  Parameters are
+// given with '-set <address>=<min>..<max>' and '-input <position>=<min>..<max>', the predicate
+// with '-where', and '-all' asks for every match rather than the first.
+func searchTool(args []string) int {
+
+	usage := "Usage: search [-all] [-workers <n>] [-threaded] -where <predicate> " +
+		"(-set <address>=<min>..<max> | -input <position>=<min>..<max>)... <image file>"
+	search := Search{}
+	where := ""
+	fileName := ""
+	threaded := false
+	for i := 0; i < len(args); i++ {
+		option := args[i]
+		needsValue := option == "-set" || option == "-input" || option == "-where" || option == "-workers"
+		if needsValue && i+1 == len(args) {
+			fmt.Println(usage)
+			return 2
+		}
+		switch option {
+		case "-all":
+			search.All = true
+		case "-threaded":
+			threaded = true
+		case "-where":
+			i++
+			where = args[i]
+		case "-workers":
+			i++
+			workers, err := strconv.Atoi(args[i])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return 2
+			}
+			search.Workers = workers
+		case "-set", "-input":
+			i++
+			n, min, max, err := parseSearchRange(args[i])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return 2
+			}
+			param := SearchParam{Address: n, Min: min, Max: max}
+			if option == "-input" {
+				param = SearchParam{Input: true, Position: n, Min: min, Max: max}
+			}
+			search.Params = append(search.Params, param)
+		default:
+			fileName = option
+		}
+	}
+	if fileName == "" || where == "" || len(search.Params) == 0 {
+		fmt.Println(usage)
+		return 2
+	}
+
+	predicate, err := parsePredicate(where)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 2
+	}
+	search.Predicate = predicate
+	vm, err := new(VM).Load(fileName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	vm.SetMaxSteps(consoleMaxSteps)
+	vm.SetLoopDetection(true)
+	if threaded {
+		vm.SetEngine(ThreadedEngine)
+	}
+	search.Program = vm
+	search.Progress = func(done, total, matches int) {
+		fmt.Fprintf(os.Stderr, "Searched %d of %d candidates, %d matches\n", done, total, matches)
+	}
+
+	matches, err := search.Run(context.Background())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	for _, match := range matches {
+		assignment := []string{}
+		for i, p := range search.Params {
+			assignment = append(assignment, fmt.Sprintf("%v=%d", p, match.Values[i]))
+		}
+		fmt.Println(strings.Join(assignment, ", "))
+	}
+	if len(matches) == 0 {
+		fmt.Println("No matches found")
+		return 1
+	}
+	return 0
+}
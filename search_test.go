@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSearchRangeTooLarge(t *testing.T) {
+	maxInt := int(^uint(0) >> 1)
+	for _, params := range [][]SearchParam{
+		{{Address: 1, Min: -maxInt - 1, Max: maxInt}},
+		{{Address: 1, Min: 0, Max: maxInt}},
+		{{Address: 1, Min: 0, Max: 1 << 32}, {Address: 2, Min: 0, Max: 1 << 32}},
+	} {
+		s := &Search{Program: loadString(t, "1,0,0,0,99"), Params: params, Predicate: func(vm *VM) bool { return true }}
+		if _, err := s.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "too many candidates") {
+			t.Errorf("searching %+v: got error %v", params, err)
+		}
+	}
+}
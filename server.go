@@ -0,0 +1,406 @@
+/*
+ * HTTP/JSON API for running Intcode programs
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverMaxSessions is the number of VMs a server keeps before refusing to create more
+const serverMaxSessions = 100
+
+// serverMaxBody is the largest request body the server reads, enough for an image of a few
+// hundred thousand cells
+const serverMaxBody = 4 << 20
+
+// serveAddress is the address the serve tool listens on by default, reachable only locally
+const serveAddress = "127.0.0.1:8019"
+
+// serverSession is a VM created through the API
+type serverSession struct {
+	mutex   sync.Mutex // serializes requests for the VM
+	vm      *VM
+	image   []int  // memory the VM was created with, restored when a run restarts
+	halted  bool   // whether the last run reached a halt instruction
+	lastErr string // error that stopped the last run, if any
+}
+
+// Server serves an HTTP/JSON API for creating VMs from images, feeding them input, running
+// or stepping them, and reading their memory and outputs.  Each VM is a separate session
+// identified by the id returned when it was created.
+//
+//	POST   /vms               {"image": "1,0,0,3,99"}          create a VM
+//	GET    /vms                                                list VM ids
+//	GET    /vms/{id}                                           state of a VM
+//	DELETE /vms/{id}                                           discard a VM
+//	POST   /vms/{id}/inputs   {"values": [1, 2]}               queue input
+//	POST   /vms/{id}/run      {"restart": false}               run until the program stops
+//	POST   /vms/{id}/step     {"count": 1}                     execute some instructions
+//	GET    /vms/{id}/memory   ?address=0&count=1               read memory
+//	POST   /vms/{id}/memory   {"address": 1, "values": [12]}   write memory
+//	GET    /vms/{id}/outputs  ?clear=true                      fetch, optionally clearing, outputs
+//
+// Runs continue from where the last one stopped unless a restart is requested, which starts
+// again from the image the VM was created with and discards its outputs.
+type Server struct {
+	mutex    sync.Mutex
+	sessions map[string]*serverSession
+	nextID   int
+	maxSteps int           // step limit of each run
+	timeout  time.Duration // time limit of each run
+}
+
+// serverState is the JSON form of a session's state
+type serverState struct {
+	ID            string `json:"id"`
+	Size          int    `json:"size"`
+	IP            int    `json:"ip"`
+	Steps         int    `json:"steps"`
+	Halted        bool   `json:"halted"`
+	Error         string `json:"error,omitempty"`
+	PendingInputs []int  `json:"pendingInputs"`
+	Outputs       int    `json:"outputs"`
+}
+
+// sessionHandler handles a request for an existing VM while holding its lock
+type sessionHandler func(s *Server, w http.ResponseWriter, r *http.Request, id string, ss *serverSession)
+
+// sessionRoutes maps the method and the action following the VM id in the path, if any, to the
+// handler for the request
+var sessionRoutes = map[string]sessionHandler{
+	"GET ":        (*Server).state,
+	"POST inputs": (*Server).inputs,
+	"POST run":    (*Server).run,
+	"POST step":   (*Server).step,
+	"GET memory":  (*Server).readMemory,
+	"POST memory": (*Server).writeMemory,
+	"GET outputs": (*Server).outputs,
+}
+
+// NewServer returns a server with no VMs whose runs are limited to the passed number of steps
+// and duration
+func NewServer(maxSteps int, timeout time.Duration) *Server {
+	return &Server{
+		sessions: map[string]*serverSession{},
+		maxSteps: maxSteps,
+		timeout:  timeout,
+	}
+}
+
+// ServeHTTP dispatches a request to the API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "vms" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, "no such endpoint '%s'", r.URL.Path)
+		return
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodPost:
+			s.create(w, r)
+		case http.MethodGet:
+			s.list(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s is not supported for '%s'", r.Method, r.URL.Path)
+		}
+		return
+	}
+
+	id := parts[1]
+	if len(parts) == 2 && r.Method == http.MethodDelete {
+		s.remove(w, r, id)
+		return
+	}
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+	handler, ok := sessionRoutes[r.Method+" "+action]
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such endpoint '%s %s'", r.Method, r.URL.Path)
+		return
+	}
+	s.mutex.Lock()
+	ss := s.sessions[id]
+	s.mutex.Unlock()
+	if ss == nil {
+		writeError(w, http.StatusNotFound, "no VM with id '%s'", id)
+		return
+	}
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	handler(s, w, r, id, ss)
+}
+
+// writeJSON sends a value as a JSON response
+func writeJSON(w http.ResponseWriter, status int, val interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(val)
+}
+
+// writeError sends an error as a JSON response
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// readJSON decodes a request body of at most serverMaxBody bytes into val, allowing an empty
+// body to leave val unchanged
+func readJSON(w http.ResponseWriter, r *http.Request, val interface{}) error {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, serverMaxBody)).Decode(val)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// stateOf returns the state of a session
+func stateOf(id string, ss *serverSession) serverState {
+	return serverState{
+		ID:            id,
+		Size:          ss.vm.Size(),
+		IP:            ss.vm.IP(),
+		Steps:         ss.vm.Steps(),
+		Halted:        ss.halted,
+		Error:         ss.lastErr,
+		PendingInputs: append([]int{}, ss.vm.PendingInputs()...),
+		Outputs:       len(ss.vm.Outputs()),
+	}
+}
+
+// create makes a VM from the image in the request
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Image string `json:"image"`
+	}
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	vm, err := new(VM).LoadFrom(strings.NewReader(req.Image))
+	if err == nil && vm.Size() == 0 {
+		err = fmt.Errorf("the image is empty")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	vm.SetMaxSteps(s.maxSteps)
+
+	s.mutex.Lock()
+	if len(s.sessions) >= serverMaxSessions {
+		s.mutex.Unlock()
+		writeError(w, http.StatusServiceUnavailable, "too many VMs--delete some first")
+		return
+	}
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	ss := &serverSession{vm: vm, image: append([]int(nil), vm.memory...)}
+	s.sessions[id] = ss
+	s.mutex.Unlock()
+	writeJSON(w, http.StatusCreated, stateOf(id, ss))
+}
+
+// list returns the ids of the VMs
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	ids := []string{}
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	s.mutex.Unlock()
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		b, _ := strconv.Atoi(ids[j])
+		return a < b
+	})
+	writeJSON(w, http.StatusOK, map[string][]string{"ids": ids})
+}
+
+// state returns the state of a VM
+func (s *Server) state(w http.ResponseWriter, r *http.Request, id string, ss *serverSession) {
+	writeJSON(w, http.StatusOK, stateOf(id, ss))
+}
+
+// remove discards a VM
+func (s *Server) remove(w http.ResponseWriter, r *http.Request, id string) {
+	s.mutex.Lock()
+	_, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no VM with id '%s'", id)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// inputs queues input for a VM
+func (s *Server) inputs(w http.ResponseWriter, r *http.Request, id string, ss *serverSession) {
+	var req struct {
+		Values []int `json:"values"`
+	}
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	ss.vm.QueueInput(req.Values...)
+	writeJSON(w, http.StatusOK, stateOf(id, ss))
+}
+
+// execute runs a VM from the start of the image it was created with, clearing its outputs, or
+// resumes it, recording how the run stopped.  Step limit aborts are not errors when stepping.
+// An error is returned only if the VM crashed rather than stopping the run with an error of
+// its own.
+func (s *Server) execute(r *http.Request, ss *serverSession, restart, stepping bool) (crash error) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			ss.halted = false
+			ss.lastErr = fmt.Sprintf("the VM crashed: %v", p)
+			crash = errors.New(ss.lastErr)
+		}
+	}()
+	var err error
+	if restart {
+		ss.vm.restore(ss.image)
+		ss.vm.ClearOutputs()
+		err = ss.vm.RunContext(ctx, false)
+	} else {
+		err = ss.vm.ResumeContext(ctx, false)
+	}
+	ss.halted = err == nil
+	ss.lastErr = ""
+	if err != nil && !(stepping && errors.Is(err, ErrStepLimit)) {
+		ss.lastErr = err.Error()
+	}
+	return nil
+}
+
+// run runs a VM until the program stops, continuing from where it last stopped unless a
+// restart from the created image is requested
+func (s *Server) run(w http.ResponseWriter, r *http.Request, id string, ss *serverSession) {
+	var req struct {
+		Restart bool `json:"restart"`
+	}
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if err := s.execute(r, ss, req.Restart, false); err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stateOf(id, ss))
+}
+
+// step executes a number of instructions of a VM, one by default
+func (s *Server) step(w http.ResponseWriter, r *http.Request, id string, ss *serverSession) {
+	req := struct {
+		Count int `json:"count"`
+	}{Count: 1}
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if req.Count <= 0 || (s.maxSteps > 0 && req.Count > s.maxSteps) {
+		writeError(w, http.StatusBadRequest, "count must be between 1 and %d", s.maxSteps)
+		return
+	}
+	ss.vm.SetMaxSteps(req.Count)
+	err := s.execute(r, ss, false, true)
+	ss.vm.SetMaxSteps(s.maxSteps)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stateOf(id, ss))
+}
+
+// readMemory returns a range of a VM's memory, a single cell by default
+func (s *Server) readMemory(w http.ResponseWriter, r *http.Request, id string, ss *serverSession) {
+	address, count := 0, 1
+	var err error
+	if text := r.URL.Query().Get("address"); text != "" {
+		if address, err = strconv.Atoi(text); err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+	if text := r.URL.Query().Get("count"); text != "" {
+		if count, err = strconv.Atoi(text); err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+	if address < 0 || count < 0 || address > ss.vm.Size() || count > ss.vm.Size()-address {
+		writeError(w, http.StatusBadRequest, "addresses %d-%d are outside memory of size %d", address, address+count-1, ss.vm.Size())
+		return
+	}
+	values := append([]int{}, ss.vm.memory[address:address+count]...)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"address": address, "values": values})
+}
+
+// writeMemory stores values in a VM's memory starting at an address
+func (s *Server) writeMemory(w http.ResponseWriter, r *http.Request, id string, ss *serverSession) {
+	var req struct {
+		Address int   `json:"address"`
+		Values  []int `json:"values"`
+	}
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if req.Address < 0 || req.Address > ss.vm.Size()-len(req.Values) {
+		writeError(w, http.StatusBadRequest, "addresses %d-%d are outside memory of size %d",
+			req.Address, req.Address+len(req.Values)-1, ss.vm.Size())
+		return
+	}
+	for i, val := range req.Values {
+		if err := ss.vm.Poke(req.Address+i, val); err != nil {
+			writeError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, stateOf(id, ss))
+}
+
+// outputs returns the values a VM has output, clearing them if asked
+func (s *Server) outputs(w http.ResponseWriter, r *http.Request, id string, ss *serverSession) {
+	outputs := append([]int{}, ss.vm.Outputs()...)
+	if clear, _ := strconv.ParseBool(r.URL.Query().Get("clear")); clear {
+		ss.vm.ClearOutputs()
+	}
+	writeJSON(w, http.StatusOK, map[string][]int{"outputs": outputs})
+}
+
+// serveTool serves the API until the process is stopped.  '-addr <host:port>' changes the
+// address listened on from the local-only default.
+func serveTool(args []string) int {
+	addr := serveAddress
+	if len(args) == 2 && args[0] == "-addr" {
+		addr = args[1]
+	} else if len(args) != 0 {
+		fmt.Println("Usage: serve [-addr <host:port>]")
+		return 2
+	}
+	fmt.Printf("Serving the Intcode API on http://%s\n", addr)
+	if err := http.ListenAndServe(addr, NewServer(consoleMaxSteps, consoleTimeout)); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
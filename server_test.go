@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// call sends a request to the server, checks the status of the response, and decodes its body
+// into val unless val is nil
+func call(t *testing.T, s *Server, method, path, body string, status int, val interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if w.Code != status {
+		t.Fatalf("%s %s: got status %d, want %d: %s", method, path, w.Code, status, w.Body)
+	}
+	if val != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), val); err != nil {
+			t.Fatalf("%s %s: %v decoding %s", method, path, err, w.Body)
+		}
+	}
+}
+
+// create makes a VM from an image, returning its state
+func create(t *testing.T, s *Server, image string) serverState {
+	t.Helper()
+	var state serverState
+	call(t, s, "POST", "/vms", fmt.Sprintf(`{"image": %q}`, image), http.StatusCreated, &state)
+	return state
+}
+
+func TestServerCreate(t *testing.T) {
+	s := NewServer(1000, time.Second)
+	state := create(t, s, "1,0,0,3,99")
+	if state.ID != "1" || state.Size != 5 || state.IP != 0 || state.Halted {
+		t.Errorf("created %+v", state)
+	}
+	call(t, s, "POST", "/vms", `{"image": ""}`, http.StatusBadRequest, nil)
+	call(t, s, "POST", "/vms", `{"image": "1,x"}`, http.StatusBadRequest, nil)
+	call(t, s, "POST", "/vms", `{"image": `, http.StatusBadRequest, nil)
+
+	var list struct{ IDs []string }
+	call(t, s, "GET", "/vms", "", http.StatusOK, &list)
+	if !reflect.DeepEqual(list.IDs, []string{"1"}) {
+		t.Errorf("listed %v", list.IDs)
+	}
+	call(t, s, "DELETE", "/vms/1", "", http.StatusNoContent, nil)
+	call(t, s, "GET", "/vms/1", "", http.StatusNotFound, nil)
+}
+
+func TestServerBodyLimit(t *testing.T) {
+	s := NewServer(1000, time.Second)
+	image := strings.Repeat("1,", serverMaxBody/2) + "99"
+	var reply map[string]string
+	call(t, s, "POST", "/vms", fmt.Sprintf(`{"image": %q}`, image), http.StatusBadRequest, &reply)
+	if !strings.Contains(reply["error"], "too large") {
+		t.Errorf("got error %q, want the body to be too large", reply["error"])
+	}
+}
+
+func TestServerStepAndRun(t *testing.T) {
+	s := NewServer(1000, time.Second)
+	create(t, s, "1,9,10,3,2,3,11,0,99,30,40,50")
+
+	var state serverState
+	call(t, s, "POST", "/vms/1/step", "", http.StatusOK, &state)
+	if state.IP != 4 || state.Steps != 1 || state.Halted || state.Error != "" {
+		t.Errorf("after one step %+v", state)
+	}
+	call(t, s, "POST", "/vms/1/step", `{"count": 0}`, http.StatusBadRequest, nil)
+	call(t, s, "POST", "/vms/1/run", "", http.StatusOK, &state)
+	if state.IP != 8 || state.Steps != 3 || !state.Halted {
+		t.Errorf("after running %+v", state)
+	}
+
+	var memory struct {
+		Address int
+		Values  []int
+	}
+	call(t, s, "GET", "/vms/1/memory?address=0&count=4", "", http.StatusOK, &memory)
+	if !reflect.DeepEqual(memory.Values, []int{3500, 9, 10, 70}) {
+		t.Errorf("memory holds %v", memory.Values)
+	}
+}
+
+func TestServerMemory(t *testing.T) {
+	s := NewServer(1000, time.Second)
+	create(t, s, "1,0,0,0,99")
+	call(t, s, "POST", "/vms/1/memory", `{"address": 1, "values": [4, 4]}`, http.StatusOK, nil)
+	call(t, s, "POST", "/vms/1/memory", `{"address": 4, "values": [1, 2]}`, http.StatusBadRequest, nil)
+	call(t, s, "GET", "/vms/1/memory?address=5", "", http.StatusBadRequest, nil)
+	call(t, s, "POST", "/vms/1/memory", `{"address": 9223372036854775807, "values": [5]}`, http.StatusBadRequest, nil)
+	call(t, s, "GET", "/vms/1/memory?address=1&count=9223372036854775807", "", http.StatusBadRequest, nil)
+	call(t, s, "POST", "/vms/1/run", "", http.StatusOK, nil)
+
+	var memory struct{ Values []int }
+	call(t, s, "GET", "/vms/1/memory?count=5", "", http.StatusOK, &memory)
+	if !reflect.DeepEqual(memory.Values, []int{198, 4, 4, 0, 99}) {
+		t.Errorf("memory holds %v", memory.Values)
+	}
+}
+
+func TestServerRestart(t *testing.T) {
+	registerDay5Opcodes(t)
+	s := NewServer(1000, time.Second)
+	create(t, s, "1,0,0,0,4,0,99")
+	call(t, s, "POST", "/vms/1/run", "", http.StatusOK, nil)
+	var state serverState
+	call(t, s, "POST", "/vms/1/run", `{"restart": true}`, http.StatusOK, &state)
+	if !state.Halted || state.Steps != 3 || state.Outputs != 1 {
+		t.Errorf("restarted run %+v", state)
+	}
+	var outputs struct{ Outputs []int }
+	call(t, s, "GET", "/vms/1/outputs", "", http.StatusOK, &outputs)
+	if !reflect.DeepEqual(outputs.Outputs, []int{2}) {
+		t.Errorf("restarted run output %v, want the output of a run of the created image", outputs.Outputs)
+	}
+}
+
+func TestServerInputsAndOutputs(t *testing.T) {
+	registerDay5Opcodes(t)
+	s := NewServer(1000, time.Second)
+	create(t, s, "3,9,1001,9,1,9,4,9,99,0") // outputs its input plus one
+
+	var state serverState
+	call(t, s, "POST", "/vms/1/run", "", http.StatusOK, &state)
+	if state.Halted || !strings.Contains(state.Error, ErrNoInput.Error()) {
+		t.Errorf("running without input %+v", state)
+	}
+	call(t, s, "POST", "/vms/1/inputs", `{"values": [41, 7]}`, http.StatusOK, &state)
+	if !reflect.DeepEqual(state.PendingInputs, []int{41, 7}) {
+		t.Errorf("queued %v", state.PendingInputs)
+	}
+	call(t, s, "POST", "/vms/1/run", "", http.StatusOK, &state)
+	if !state.Halted || state.Outputs != 1 || !reflect.DeepEqual(state.PendingInputs, []int{7}) {
+		t.Errorf("after running %+v", state)
+	}
+
+	var outputs struct{ Outputs []int }
+	call(t, s, "GET", "/vms/1/outputs?clear=true", "", http.StatusOK, &outputs)
+	if !reflect.DeepEqual(outputs.Outputs, []int{42}) {
+		t.Errorf("output %v", outputs.Outputs)
+	}
+	call(t, s, "GET", "/vms/1/outputs", "", http.StatusOK, &outputs)
+	if len(outputs.Outputs) != 0 {
+		t.Errorf("output %v after clearing", outputs.Outputs)
+	}
+}
+
+func TestServerSessionIsolation(t *testing.T) {
+	registerDay5Opcodes(t)
+	s := NewServer(1000, time.Second)
+	create(t, s, "3,0,4,0,99")
+	create(t, s, "3,0,4,0,99")
+	call(t, s, "POST", "/vms/1/inputs", `{"values": [1]}`, http.StatusOK, nil)
+	call(t, s, "POST", "/vms/2/inputs", `{"values": [2]}`, http.StatusOK, nil)
+	call(t, s, "POST", "/vms/2/run", "", http.StatusOK, nil)
+
+	var state serverState
+	call(t, s, "GET", "/vms/1", "", http.StatusOK, &state)
+	if state.Steps != 0 || state.Outputs != 0 || !reflect.DeepEqual(state.PendingInputs, []int{1}) {
+		t.Errorf("running VM 2 changed VM 1: %+v", state)
+	}
+	var outputs struct{ Outputs []int }
+	call(t, s, "GET", "/vms/2/outputs", "", http.StatusOK, &outputs)
+	if !reflect.DeepEqual(outputs.Outputs, []int{2}) {
+		t.Errorf("VM 2 output %v", outputs.Outputs)
+	}
+	var memory struct{ Values []int }
+	call(t, s, "GET", "/vms/1/memory", "", http.StatusOK, &memory)
+	if !reflect.DeepEqual(memory.Values, []int{3}) {
+		t.Errorf("VM 1 memory holds %v", memory.Values)
+	}
+}
+
+func TestServerRunFaults(t *testing.T) {
+	s := NewServer(1000, time.Second)
+	create(t, s, "1,0,0")
+	var state serverState
+	call(t, s, "POST", "/vms/1/run", "", http.StatusOK, &state)
+	if state.Halted || !strings.Contains(state.Error, "memory stops") {
+		t.Errorf("running a truncated image %+v", state)
+	}
+
+	op := Opcode{Number: 42, Mnemonic: "CRASH", Exec: func(vm *VM, a *Args) error { panic("crashed") }}
+	if err := RegisterOpcode(op); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { UnregisterOpcode(42) })
+	create(t, s, "42,99")
+	var reply map[string]string
+	call(t, s, "POST", "/vms/2/run", "", http.StatusInternalServerError, &reply)
+	if !strings.Contains(reply["error"], "crashed") {
+		t.Errorf("got error %q for a crashing VM", reply["error"])
+	}
+	call(t, s, "GET", "/vms/2", "", http.StatusOK, &state)
+	if !strings.Contains(state.Error, "crashed") {
+		t.Errorf("crashed VM reported %+v", state)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ * Named VM sessions for the console
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultSession is the name of the session the console starts with
+const defaultSession = "main"
+
+// consoleSession is a VM the console holds under a name
+type consoleSession struct {
+	name        string
+	vm          *VM
+	file        string  // file last loaded into the VM
+	snapshot    bool    // whether the file is a snapshot rather than a program image
+	patchFile   string  // patch file applied when the file was loaded, if any
+	patches     []patch // patches applied since the file was loaded, starting with the patch file's
+	filePatches int     // number of patches read from the patch file
+	loaded      *VM     // copy of the VM as it was loaded and patched, restored by reset
+
+	mutex  sync.Mutex  // guards the fields below, which a background run changes
+	job    *consoleJob // run in progress, nil if the program is not running
+	state  runState    // what the program is doing
+	runErr error       // error that stopped the last run
+}
+
+// newConsoleSession returns a session with an empty VM using the console's step limit
+func newConsoleSession(name string) *consoleSession {
+	vm := new(VM)
+	vm.SetMaxSteps(consoleMaxSteps)
+	vm.SetInputFallback(consoleInput)
+	return &consoleSession{name: name, vm: vm}
+}
+
+// load loads a program image, or a snapshot, from a file into the session's VM, applies the
+// patches in a patch file if one is named, and keeps a copy to reset to.  Any profile or trace
+// is started afresh.  The VM is left as it was if the file or the patches cannot be loaded.
+func (s *consoleSession) load(fileName, patchFile string, snapshot bool) error {
+	vm := s.vm.Clone()
+	var err error
+	if snapshot {
+		_, err = vm.LoadSnapshot(fileName)
+	} else {
+		_, err = vm.Load(fileName)
+	}
+	if err != nil {
+		return err
+	}
+	patches := []patch{}
+	if patchFile != "" {
+		if patches, err = loadPatches(patchFile, vm.Size()); err != nil {
+			return err
+		}
+	}
+	for _, p := range patches {
+		if err := p.apply(vm); err != nil {
+			return fmt.Errorf("%s: %v", patchFile, err)
+		}
+	}
+
+	s.vm.memory, s.vm.ip, s.vm.steps = vm.memory, vm.ip, vm.steps
+	s.vm.inputs, s.vm.outputs = vm.inputs, vm.outputs
+	s.vm.threaded = nil
+	if s.vm.Profiler() != nil {
+		s.vm.EnableProfiler()
+	}
+	if s.vm.Trace() != nil {
+		s.vm.EnableTrace()
+	}
+	s.file, s.snapshot, s.patchFile, s.patches = fileName, snapshot, patchFile, patches
+	s.filePatches = len(patches)
+	s.loaded = s.vm.Clone()
+	s.state = runIdle
+	return nil
+}
+
+// patch applies a patch to the session's VM recording it so it can be saved to a patch file
+func (s *consoleSession) patch(p patch) error {
+	if err := p.apply(s.vm); err != nil {
+		return err
+	}
+	s.patches = append(s.patches, p)
+	return nil
+}
+
+// reload rereads the file last loaded into the session's VM and its patch file, discarding
+// other patches
+func (s *consoleSession) reload() error {
+	if s.file == "" {
+		return fmt.Errorf("nothing has been loaded")
+	}
+	return s.load(s.file, s.patchFile, s.snapshot)
+}
+
+// reset returns the session's VM to its state when last loaded, restoring memory, the IP, the
+// step count, and I/O.  Patches made since are undone.
+func (s *consoleSession) reset() error {
+	if s.loaded == nil {
+		return fmt.Errorf("nothing has been loaded")
+	}
+	if s.vm.running {
+		return fmt.Errorf("cannot reset a running VM")
+	}
+	s.vm.restore(s.loaded.memory)
+	s.vm.ip = s.loaded.ip
+	s.vm.steps = s.loaded.steps
+	s.vm.inputs = append([]int(nil), s.loaded.inputs...)
+	s.vm.outputs = append([]int(nil), s.loaded.outputs...)
+	s.patches = s.patches[:s.filePatches]
+	s.state = runIdle
+	return nil
+}
+
+// consoleInput asks the user for a value when a program run from the console reads input
+// with none queued.  An empty line stops the run.
+func consoleInput() (int, error) {
+	for {
+		text, err := prompt("Program is waiting for input (empty line to stop):", "?")
+		text = strings.TrimSpace(text)
+		if err != nil || text == "" {
+			return 0, ErrNoInput
+		}
+		val, err := strconv.Atoi(text)
+		if err == nil {
+			return val, nil
+		}
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+// consoleSessions holds the console's sessions by name
+type consoleSessions map[string]*consoleSession
+
+// names returns the names of the sessions in order
+func (cs consoleSessions) names() []string {
+	names := make([]string, 0, len(cs))
+	for name := range cs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// print lists the sessions, marking the current one
+func (cs consoleSessions) print(current string) {
+	for _, name := range cs.names() {
+		s := cs[name]
+		marker := " "
+		if name == current {
+			marker = "*"
+		}
+		file := s.file
+		if file == "" {
+			file = "(empty)"
+		}
+		if s.busy() {
+			fmt.Printf("%s %-10s %-20s %6d cells  running\n", marker, name, file, s.vm.Size())
+			continue
+		}
+		fmt.Printf("%s %-10s %-20s %6d cells  IP %-6d %d steps\n", marker, name, file, s.vm.Size(), s.vm.IP(), s.vm.Steps())
+	}
+}
+
+// memoryDiff is an address whose contents differ between two memories.  A value is missing
+// when the address is beyond the end of that memory.
+type memoryDiff struct {
+	address  int
+	a, b     int
+	inA, inB bool
+}
+
+// diffMemory returns the addresses whose contents differ between two memories
+func diffMemory(a, b []int) []memoryDiff {
+	diffs := []memoryDiff{}
+	size := len(a)
+	if len(b) > size {
+		size = len(b)
+	}
+	for address := 0; address < size; address++ {
+		d := memoryDiff{address: address, inA: address < len(a), inB: address < len(b)}
+		if d.inA {
+			d.a = a[address]
+		}
+		if d.inB {
+			d.b = b[address]
+		}
+		if d.inA != d.inB || d.a != d.b {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
+
+// printMemoryDiff shows the addresses whose contents differ between the memories of two
+// sessions
+func printMemoryDiff(a, b *consoleSession) {
+	diffs := diffMemory(a.vm.memory, b.vm.memory)
+	if len(diffs) == 0 {
+		fmt.Printf("Memory of '%s' and '%s' is identical (%d cells)\n", a.name, b.name, a.vm.Size())
+		return
+	}
+	show := func(val int, present bool) string {
+		if !present {
+			return "-"
+		}
+		return fmt.Sprint(val)
+	}
+	fmt.Printf("%8s  %12s  %12s\n", "address", a.name, b.name)
+	for _, d := range diffs {
+		fmt.Printf("%8d  %12s  %12s\n", d.address, show(d.a, d.inA), show(d.b, d.inB))
+	}
+	fmt.Printf("%d cells differ\n", len(diffs))
+}
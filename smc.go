@@ -0,0 +1,163 @@
+/*
+ * Self-modifying code detection for the ship's computer
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// SelfModifyMode selects how the VM treats a running program writing to its own code
+type SelfModifyMode int
+
+const (
+	// SelfModifyIgnore does not track writes to code
+	SelfModifyIgnore SelfModifyMode = iota
+	// SelfModifyReport records each write to code so it can be reported after the run
+	SelfModifyReport
+	// SelfModifyStrict stops the run with a SelfModifyError at the first write to code
+	SelfModifyStrict
+)
+
+// String returns the name of the mode
+func (m SelfModifyMode) String() string {
+	switch m {
+	case SelfModifyIgnore:
+		return "off"
+	case SelfModifyReport:
+		return "report"
+	case SelfModifyStrict:
+		return "strict"
+	default:
+		return fmt.Sprintf("mode(%d)", int(m))
+	}
+}
+
+// SelfModification records a write by a running program to a cell that has been or will be
+// executed as part of an instruction
+type SelfModification struct {
+	IP      int  // address of the instruction that made the write
+	Address int  // address of the cell written
+	Old     int  // value of the cell before the write
+	New     int  // value written
+	Opcode  bool // whether the cell holds an instruction's opcode rather than a parameter
+}
+
+// String formats the modification for display
+func (sm SelfModification) String() string {
+	kind := "parameter"
+	if sm.Opcode {
+		kind = "opcode"
+	}
+	return fmt.Sprintf("%4d: wrote %d over %s %d at %d", sm.IP, sm.New, kind, sm.Old, sm.Address)
+}
+
+// SelfModifyError reports a write to code made while the VM is in strict mode
+type SelfModifyError struct {
+	SelfModification
+}
+
+// Error formats the modification as an error, the run adding the position of the instruction
+func (e *SelfModifyError) Error() string {
+	kind := "parameter"
+	if e.Opcode {
+		kind = "opcode"
+	}
+	return fmt.Sprintf("attempt to modify the %s at address %d", kind, e.Address)
+}
+
+// codeCell flags how a memory cell takes part in the program's instructions
+type codeCell int
+
+const (
+	// codeParameter the cell holds an instruction's parameter
+	codeParameter codeCell = 1 << iota
+	// codeOpcode the cell holds an instruction's opcode
+	codeOpcode
+)
+
+// smcTracker knows which cells hold code during a run and records writes to them
+type smcTracker struct {
+	cells   []codeCell         // code found statically or executed so far, indexed by address
+	current int                // address of the instruction being executed
+	log     []SelfModification // writes to code made so far
+}
+
+// newSMCTracker returns a tracker treating the instructions found by sweeping the passed
+// memory as code that will be executed
+func newSMCTracker(memory []int) *smcTracker {
+	t := &smcTracker{cells: make([]codeCell, len(memory))}
+	for _, si := range sweep(memory) {
+		t.markExecuted(si.address, si.inst.width)
+	}
+	return t
+}
+
+// markExecuted records that an instruction of the passed width is executing at the passed
+// address
+func (t *smcTracker) markExecuted(address, width int) {
+	t.current = address
+	for offset := 0; offset < width && address+offset < len(t.cells); offset++ {
+		if offset == 0 {
+			t.cells[address] |= codeOpcode
+		} else {
+			t.cells[address+offset] |= codeParameter
+		}
+	}
+}
+
+// recordWrite checks a write of val over old at the passed address returning the modification
+// if the cell holds code
+func (t *smcTracker) recordWrite(address, old, val int) *SelfModification {
+	if address < 0 || address >= len(t.cells) || t.cells[address] == 0 {
+		return nil
+	}
+	sm := SelfModification{
+		IP:      t.current,
+		Address: address,
+		Old:     old,
+		New:     val,
+		Opcode:  t.cells[address]&codeOpcode != 0,
+	}
+	t.log = append(t.log, sm)
+	return &sm
+}
+
+// checkWrite is called before the running program writes val to the passed address and
+// records, or in strict mode refuses, writes to code
+func (vm *VM) checkWrite(address, val int) {
+	sm := vm.smc.recordWrite(address, vm.memory[address], val)
+	if sm != nil && vm.selfModify == SelfModifyStrict {
+		panic(runFault{&SelfModifyError{*sm}})
+	}
+}
+
+// SetSelfModify selects how subsequent runs treat writes by the program to its own code
+func (vm *VM) SetSelfModify(mode SelfModifyMode) {
+	vm.selfModify = mode
+}
+
+// SelfModify returns how runs treat writes by the program to its own code
+func (vm *VM) SelfModify() SelfModifyMode {
+	return vm.selfModify
+}
+
+// SelfModifications returns the writes to code made during the last run while self-modifying
+// code was being reported or refused
+func (vm *VM) SelfModifications() []SelfModification {
+	return vm.smcLog
+}
+
+// ReportSelfModifications writes the writes to code made during the last run to w
+func (vm *VM) ReportSelfModifications(w io.Writer) {
+	if len(vm.smcLog) == 0 {
+		fmt.Fprintln(w, "No writes to code recorded")
+		return
+	}
+	fmt.Fprintf(w, "%d writes to code:\n", len(vm.smcLog))
+	for _, sm := range vm.smcLog {
+		fmt.Fprintf(w, "\t%v\n", sm)
+	}
+}
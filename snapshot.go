@@ -0,0 +1,240 @@
+/*
+ * Binary snapshots of the ship's computer state
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+)
+
+// snapshotMagic starts every snapshot file
+const snapshotMagic = "ICVM"
+
+// snapshotVersion is the version of the snapshot format written.  Version 1 holds, as
+// varints after the magic and version: the IP, the relative base, the step count, then the
+// memory, pending input, and outputs each as a count followed by the values, and finally a
+// big-endian CRC-32 of everything before it.
+const snapshotVersion = 1
+
+// ErrBadSnapshot is the cause reported for snapshots that are truncated or corrupt
+var ErrBadSnapshot = errors.New("not a valid snapshot")
+
+// WriteSnapshot writes the state of the VM to w: its memory, the IP where the last run stopped,
+// the step count, pending input, and outputs.  The VM has no relative base so zero is written
+// for it.
+func (vm *VM) WriteSnapshot(w io.Writer) error {
+	if vm.running {
+		return fmt.Errorf("cannot snapshot a running VM")
+	}
+	var buf bytes.Buffer
+	buf.WriteString(snapshotMagic)
+	scratch := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(val int) { buf.Write(scratch[:binary.PutUvarint(scratch, uint64(val))]) }
+	putVarint := func(val int) { buf.Write(scratch[:binary.PutVarint(scratch, int64(val))]) }
+	putVals := func(vals []int) {
+		putUvarint(len(vals))
+		for _, val := range vals {
+			putVarint(val)
+		}
+	}
+	putUvarint(snapshotVersion)
+	putVarint(vm.ip)
+	putVarint(0) // relative base
+	putUvarint(vm.steps)
+	putVals(vm.memory)
+	putVals(vm.inputs)
+	putVals(vm.outputs)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// SaveSnapshot writes the state of the VM to the named file
+func (vm *VM) SaveSnapshot(fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := vm.WriteSnapshot(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadSnapshotFrom replaces the state of the VM with a snapshot read from r so that the run it
+// was taken from can be continued with Resume.  Settings such as the engine are kept.
+func (vm *VM) LoadSnapshotFrom(r io.Reader) (*VM, error) {
+	if vm.running {
+		return nil, fmt.Errorf("cannot restore a snapshot into a running VM")
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(snapshotMagic)+crc32.Size || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, ErrBadSnapshot
+	}
+	body := data[:len(data)-crc32.Size]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[len(body):]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	reader := bytes.NewReader(body[len(snapshotMagic):])
+	var readErr error
+	getUvarint := func() int {
+		val, err := binary.ReadUvarint(reader)
+		if err != nil && readErr == nil {
+			readErr = err
+		}
+		if val > uint64(^uint(0)>>1) {
+			if readErr == nil {
+				readErr = ErrBadSnapshot // too large for an int, which would come out negative
+			}
+			return 0
+		}
+		return int(val)
+	}
+	getVarint := func() int {
+		val, err := binary.ReadVarint(reader)
+		if err != nil && readErr == nil {
+			readErr = err
+		}
+		return int(val)
+	}
+	getVals := func() []int {
+		count := getUvarint()
+		if readErr != nil || count > reader.Len() {
+			readErr = ErrBadSnapshot
+			return nil
+		}
+		vals := make([]int, count)
+		for i := range vals {
+			vals[i] = getVarint()
+		}
+		return vals
+	}
+
+	if version := getUvarint(); readErr == nil && version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot version %d is not supported (expected %d)", version, snapshotVersion)
+	}
+	ip := getVarint()
+	relativeBase := getVarint()
+	steps := getUvarint()
+	memory := getVals()
+	inputs := getVals()
+	outputs := getVals()
+	if readErr != nil || reader.Len() != 0 {
+		return nil, fmt.Errorf("%w: truncated or malformed", ErrBadSnapshot)
+	}
+	if relativeBase != 0 {
+		return nil, fmt.Errorf("snapshot has relative base %d but the VM has no relative base", relativeBase)
+	}
+
+	vm.memory = memory
+	vm.ip = ip
+	vm.steps = steps
+	vm.inputs = inputs
+	vm.outputs = outputs
+	vm.threaded = nil
+	return vm, nil
+}
+
+// LoadSnapshot replaces the state of the VM with the snapshot in the named file
+func (vm *VM) LoadSnapshot(fileName string) (*VM, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return vm.LoadSnapshotFrom(bufio.NewReader(file))
+}
+
+// printSnapshot summarizes the state of a VM restored from a snapshot
+func printSnapshot(vm *VM) {
+	fmt.Printf("IP %d after %d steps, %d cells of memory\n", vm.IP(), vm.Steps(), vm.Size())
+	fmt.Printf("Pending input: %v\n", vm.PendingInputs())
+	fmt.Printf("Outputs: %v\n", vm.Outputs())
+}
+
+// snapshotTool creates, inspects, and resumes snapshots.  'create <image> <snapshot> [<steps>]'
+// runs an image for at most the passed number of steps, or until it stops, and saves its state;
+// 'info <snapshot>' summarizes a snapshot; and 'resume <snapshot> [<new snapshot>]' continues
+// the run a snapshot was taken from, optionally saving the state it stops in.
+func snapshotTool(args []string) int {
+
+	usage := "Usage: snapshot create <image file> <snapshot file> [<steps>] | " +
+		"info <snapshot file> | resume <snapshot file> [<new snapshot file>]"
+	if len(args) < 2 {
+		fmt.Println(usage)
+		return 2
+	}
+	vm := new(VM)
+	vm.SetMaxSteps(consoleMaxSteps)
+	var runErr error
+	save := ""
+	switch args[0] {
+	case "create":
+		if len(args) < 3 || len(args) > 4 {
+			fmt.Println(usage)
+			return 2
+		}
+		if len(args) == 4 {
+			steps, err := strconv.Atoi(args[3])
+			if err != nil || steps <= 0 {
+				fmt.Println("Please provide a positive number of steps")
+				return 2
+			}
+			vm.SetMaxSteps(steps)
+		}
+		if _, err := vm.Load(args[1]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		runErr = vm.Run(false)
+		save = args[2]
+	case "info", "resume":
+		if len(args) > 3 || (args[0] == "info" && len(args) > 2) {
+			fmt.Println(usage)
+			return 2
+		}
+		if _, err := vm.LoadSnapshot(args[1]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		if args[0] == "resume" {
+			ctx, cancel := context.WithTimeout(context.Background(), consoleTimeout)
+			runErr = vm.ResumeContext(ctx, false)
+			cancel()
+			if len(args) == 3 {
+				save = args[2]
+			}
+		}
+	default:
+		fmt.Println(usage)
+		return 2
+	}
+
+	if runErr != nil {
+		fmt.Printf("Run stopped: %v\n", runErr)
+	}
+	printSnapshot(vm)
+	if save != "" {
+		if err := vm.SaveSnapshot(save); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Snapshot written to %s\n", save)
+	}
+	return 0
+}
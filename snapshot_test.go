@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+// craftSnapshot returns a snapshot with a valid checksum holding the passed fields as uvarints
+func craftSnapshot(fields ...uint64) []byte {
+	buf := bytes.NewBufferString(snapshotMagic)
+	scratch := make([]byte, binary.MaxVarintLen64)
+	for _, field := range fields {
+		buf.Write(scratch[:binary.PutUvarint(scratch, field)])
+	}
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	vm := loadString(t, "1,0,0,0,2,0,0,0,99")
+	vm.SetMaxSteps(1)
+	if err := vm.Run(false); err == nil {
+		t.Fatal("got no error stopping after one step")
+	}
+	vm.QueueInput(-5, 7)
+	vm.outputs = []int{42}
+	var snapshot bytes.Buffer
+	if err := vm.WriteSnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := new(VM).LoadSnapshotFrom(&snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.IP() != vm.IP() || restored.Steps() != vm.Steps() || !reflect.DeepEqual(restored.memory, vm.memory) ||
+		!reflect.DeepEqual(restored.PendingInputs(), vm.PendingInputs()) || !reflect.DeepEqual(restored.Outputs(), vm.Outputs()) {
+		t.Errorf("restored ip %d, steps %d, memory %v, inputs %v, outputs %v", restored.IP(), restored.Steps(),
+			restored.memory, restored.PendingInputs(), restored.Outputs())
+	}
+	if err := restored.Resume(false); err != nil || restored.memory[0] != 4 {
+		t.Errorf("resuming the restored VM: memory %v, error %v", restored.memory, err)
+	}
+}
+
+func TestBadSnapshotsRejected(t *testing.T) {
+	var snapshot bytes.Buffer
+	if err := loadString(t, "1,0,0,0,99").WriteSnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	good := snapshot.Bytes()
+	bad := map[string][]byte{
+		"corrupt":                 append([]byte{}, good...),
+		"huge memory count":       craftSnapshot(snapshotVersion, 0, 0, 0, ^uint64(0)),
+		"negative memory count":   craftSnapshot(snapshotVersion, 0, 0, 0, 1<<63),
+		"steps above the maximum": craftSnapshot(snapshotVersion, 0, 0, 1<<63, 0, 0, 0),
+	}
+	bad["corrupt"][len(snapshotMagic)+2] ^= 0xff
+	for i := 0; i < len(good); i++ {
+		bad[fmt.Sprintf("truncated to %d bytes", i)] = good[:i]
+	}
+	for name, data := range bad {
+		if _, err := new(VM).LoadSnapshotFrom(bytes.NewReader(data)); !errors.Is(err, ErrBadSnapshot) {
+			t.Errorf("%s: got error %v, want a bad snapshot", name, err)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+/*
+ * Symbolic evaluation of Intcode programs
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Polynomial is an integer polynomial over named variables.  Each monomial is keyed by the
+// names of its variables sorted and joined by '*', the empty key holding the constant term.
+type Polynomial map[string]int
+
+// constantPoly returns the polynomial for a constant
+func constantPoly(val int) Polynomial {
+	if val == 0 {
+		return Polynomial{}
+	}
+	return Polynomial{"": val}
+}
+
+// variablePoly returns the polynomial for a single variable
+func variablePoly(name string) Polynomial {
+	return Polynomial{name: 1}
+}
+
+// factors returns the variables of a monomial key
+func factors(monomial string) []string {
+	if monomial == "" {
+		return nil
+	}
+	return strings.Split(monomial, "*")
+}
+
+// Add returns the sum of two polynomials
+func (p Polynomial) Add(q Polynomial) Polynomial {
+	sum := Polynomial{}
+	for monomial, coeff := range p {
+		sum[monomial] += coeff
+	}
+	for monomial, coeff := range q {
+		sum[monomial] += coeff
+	}
+	return sum.trim()
+}
+
+// Mul returns the product of two polynomials
+func (p Polynomial) Mul(q Polynomial) Polynomial {
+	product := Polynomial{}
+	for m1, c1 := range p {
+		for m2, c2 := range q {
+			vars := append(factors(m1), factors(m2)...)
+			sort.Strings(vars)
+			product[strings.Join(vars, "*")] += c1 * c2
+		}
+	}
+	return product.trim()
+}
+
+// trim drops the monomials with a zero coefficient
+func (p Polynomial) trim() Polynomial {
+	for monomial, coeff := range p {
+		if coeff == 0 {
+			delete(p, monomial)
+		}
+	}
+	return p
+}
+
+// Constant returns the value of a polynomial without variables and whether it has none
+func (p Polynomial) Constant() (int, bool) {
+	for monomial := range p {
+		if monomial != "" {
+			return 0, false
+		}
+	}
+	return p[""], true
+}
+
+// Eval returns the value of the polynomial for the passed values of its variables
+func (p Polynomial) Eval(vals map[string]int) int {
+	total := 0
+	for monomial, coeff := range p {
+		term := coeff
+		for _, name := range factors(monomial) {
+			term *= vals[name]
+		}
+		total += term
+	}
+	return total
+}
+
+// Variables returns the names of the variables appearing in the polynomial in sorted order
+func (p Polynomial) Variables() []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for monomial := range p {
+		for _, name := range factors(monomial) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String formats the polynomial with its highest degree terms first
+func (p Polynomial) String() string {
+	monomials := []string{}
+	for monomial := range p {
+		monomials = append(monomials, monomial)
+	}
+	sort.Slice(monomials, func(i, j int) bool {
+		di, dj := len(factors(monomials[i])), len(factors(monomials[j]))
+		if di != dj {
+			return di > dj
+		}
+		return monomials[i] < monomials[j]
+	})
+	var b strings.Builder
+	for i, monomial := range monomials {
+		coeff := p[monomial]
+		switch {
+		case i == 0 && coeff < 0:
+			b.WriteString("-")
+		case i > 0 && coeff < 0:
+			b.WriteString(" - ")
+		case i > 0:
+			b.WriteString(" + ")
+		}
+		if coeff < 0 {
+			coeff = -coeff
+		}
+		switch {
+		case monomial == "":
+			b.WriteString(strconv.Itoa(coeff))
+		case coeff == 1:
+			b.WriteString(monomial)
+		default:
+			b.WriteString(strconv.Itoa(coeff) + "*" + monomial)
+		}
+	}
+	if b.Len() == 0 {
+		return "0"
+	}
+	return b.String()
+}
+
+// linearIn splits the polynomial into a*v + c for the passed variable reporting false if the
+// variable appears with a degree higher than one
+func (p Polynomial) linearIn(name string) (Polynomial, Polynomial, bool) {
+	a, c := Polynomial{}, Polynomial{}
+	for monomial, coeff := range p {
+		vars := factors(monomial)
+		count := 0
+		rest := []string{}
+		for _, v := range vars {
+			if v == name {
+				count++
+			} else {
+				rest = append(rest, v)
+			}
+		}
+		switch count {
+		case 0:
+			c[monomial] += coeff
+		case 1:
+			a[strings.Join(rest, "*")] += coeff
+		default:
+			return nil, nil, false
+		}
+	}
+	return a.trim(), c.trim(), true
+}
+
+// VarRange is the inclusive range of values a variable may take
+type VarRange struct {
+	Min int
+	Max int
+}
+
+// Solve returns every assignment of the polynomial's variables within the passed ranges for
+// which it equals target.  All but the last variable are enumerated; the last is solved for
+// directly when the polynomial is linear in it and tried across its range otherwise.
+func (p Polynomial) Solve(target int, ranges map[string]VarRange) ([]map[string]int, error) {
+	names := p.Variables()
+	for _, name := range names {
+		if _, ok := ranges[name]; !ok {
+			return nil, fmt.Errorf("no range given for variable %s", name)
+		}
+	}
+	if len(names) == 0 {
+		if p.Eval(nil) == target {
+			return []map[string]int{{}}, nil
+		}
+		return nil, nil
+	}
+
+	last := names[len(names)-1]
+	a, c, linear := p.linearIn(last)
+	solutions := []map[string]int{}
+	vals := map[string]int{}
+	var enumerate func(i int)
+	enumerate = func(i int) {
+		if i < len(names)-1 {
+			for val := ranges[names[i]].Min; val <= ranges[names[i]].Max; val++ {
+				vals[names[i]] = val
+				enumerate(i + 1)
+			}
+			return
+		}
+		try := func(val int) {
+			if val < ranges[last].Min || val > ranges[last].Max {
+				return
+			}
+			vals[last] = val
+			if p.Eval(vals) == target {
+				solution := map[string]int{}
+				for name, v := range vals {
+					solution[name] = v
+				}
+				solutions = append(solutions, solution)
+			}
+		}
+		if linear {
+			coeff, rest := a.Eval(vals), target-c.Eval(vals)
+			if coeff != 0 {
+				if rest%coeff == 0 {
+					try(rest / coeff)
+				}
+				return
+			}
+			if rest != 0 {
+				return
+			}
+		}
+		for val := ranges[last].Min; val <= ranges[last].Max; val++ {
+			try(val)
+		}
+	}
+	enumerate(0)
+	return solutions, nil
+}
+
+// symValue is the content of a memory cell during symbolic evaluation.  Values read from an
+// address that depends on a variable are opaque and may not be used for anything but data.
+type symValue struct {
+	poly   Polynomial
+	opaque bool
+}
+
+// symbolicOps maps the opcodes, of instructions combining two inputs into an output, that can
+// be evaluated symbolically to their operation
+var symbolicOps = map[int]func(a, b Polynomial) Polynomial{
+	1: Polynomial.Add,
+	2: Polynomial.Mul,
+}
+
+// EvaluateSymbolic runs an image with the cells in symbols holding named variables instead of
+// values and returns the polynomial left at the result address.  The program may only use
+// variables as data: opcodes and addresses depending on them cannot be evaluated.
+func EvaluateSymbolic(image []int, symbols map[int]string, result int, maxSteps int) (Polynomial, error) {
+
+	memory := make([]symValue, len(image))
+	for address, val := range image {
+		memory[address] = symValue{poly: constantPoly(val)}
+	}
+	for address, name := range symbols {
+		if address < 0 || address >= len(memory) {
+			return nil, fmt.Errorf("symbol %s placed at address %d outside memory", name, address)
+		}
+		memory[address] = symValue{poly: variablePoly(name)}
+	}
+
+	// concrete returns the value of a cell that must not depend on any variable
+	concrete := func(address int, use string) (int, error) {
+		if address < 0 || address >= len(memory) {
+			return 0, fmt.Errorf("attempt to read from address %d but memory stops at %d", address, len(memory))
+		}
+		val, ok := memory[address].poly.Constant()
+		if !ok || memory[address].opaque {
+			return 0, fmt.Errorf("%s at address %d depends on %v", use, address, memory[address].poly)
+		}
+		return val, nil
+	}
+
+	ip := 0
+	for steps := 0; maxSteps <= 0 || steps < maxSteps; steps++ {
+		val, err := concrete(ip, "opcode")
+		if err != nil {
+			return nil, err
+		}
+		inst, modes, ok := decode(val)
+		if !ok {
+			return nil, fmt.Errorf("%v encountered at position %v", decodeError(val), ip)
+		}
+		if inst.halts {
+			if result < 0 || result >= len(memory) {
+				return nil, fmt.Errorf("result address %d outside memory", result)
+			}
+			if memory[result].opaque {
+				return nil, fmt.Errorf("result at address %d was read from an address depending on the variables", result)
+			}
+			return memory[result].poly, nil
+		}
+		op, ok := symbolicOps[inst.opcode]
+		if !ok {
+			return nil, fmt.Errorf("%s at position %d cannot be evaluated symbolically", inst.mnemonic, ip)
+		}
+		if ip+inst.width > len(memory) {
+			return nil, fmt.Errorf("no halt instruction occured before end of memory")
+		}
+
+		// Load both inputs, treating reads through addresses that depend on the variables as
+		// opaque values
+		var inputs [2]symValue
+		for param := 0; param < 2; param++ {
+			cell := ip + 1 + param
+			if modes[param] == ImmediateMode {
+				inputs[param] = memory[cell]
+				continue
+			}
+			address, err := concrete(cell, "read address")
+			if err != nil {
+				inputs[param] = symValue{poly: Polynomial{}, opaque: true}
+				continue
+			}
+			if address < 0 || address >= len(memory) {
+				return nil, fmt.Errorf("attempt to read from address %d but memory stops at %d at position %d", address, len(memory), ip)
+			}
+			inputs[param] = memory[address]
+		}
+
+		target := ip + 3
+		if modes[2] == PositionMode {
+			if target, err = concrete(ip+3, "write address"); err != nil {
+				return nil, fmt.Errorf("%v at position %d", err, ip)
+			}
+			if target < 0 || target >= len(memory) {
+				return nil, fmt.Errorf("attempt to write to address %d but memory stops at %d at position %d", target, len(memory), ip)
+			}
+		}
+		memory[target] = symValue{
+			poly:   op(inputs[0].poly, inputs[1].poly),
+			opaque: inputs[0].opaque || inputs[1].opaque,
+		}
+		ip += inst.width
+	}
+	return nil, fmt.Errorf("step limit of %d reached during symbolic evaluation", maxSteps)
+}
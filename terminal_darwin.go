@@ -0,0 +1,12 @@
+/*
+ * Terminal control requests on macOS
+ */
+
+package main
+
+import "syscall"
+
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
@@ -0,0 +1,12 @@
+/*
+ * Terminal control requests on Linux
+ */
+
+package main
+
+import "syscall"
+
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+/*
+ * Raw terminal mode on systems without termios
+ */
+
+package main
+
+import "errors"
+
+// makeRaw reports that raw mode is unavailable so that lines are read without editing
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on this system")
+}
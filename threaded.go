@@ -0,0 +1,160 @@
+/*
+ * Threaded code execution engine for the ship's computer
+ */
+
+package main
+
+import (
+	"fmt"
+)
+
+// Engine selects how the VM executes a program
+type Engine int
+
+const (
+	// InterpreterEngine decodes each instruction every time it is executed
+	InterpreterEngine Engine = iota
+	// ThreadedEngine decodes each instruction once into a closure that is reused until the
+	// program overwrites the instruction's opcode
+	ThreadedEngine
+)
+
+// String returns the name of the engine
+func (e Engine) String() string {
+	switch e {
+	case InterpreterEngine:
+		return "interpreter"
+	case ThreadedEngine:
+		return "threaded"
+	default:
+		return fmt.Sprintf("engine(%d)", int(e))
+	}
+}
+
+// threadedOp is an instruction pre-decoded by the threaded engine
+type threadedOp struct {
+	inst  *instruction     // instruction decoded at the op's address
+	modes paramModes       // modes of the instruction's parameters
+	run   func(vm *VM) int // carries out the instruction returning the next address, nil when it halts
+}
+
+// threadedCode holds the ops compiled from a VM's memory indexed by address
+type threadedCode struct {
+	ops        []*threadedOp
+	generation int // opcodeGeneration of the instruction set the ops were compiled from
+}
+
+// newThreadedCode returns an empty cache of ops for a memory of the passed size
+func newThreadedCode(size int) *threadedCode {
+	return &threadedCode{ops: make([]*threadedOp, size), generation: opcodeGeneration}
+}
+
+// invalidate discards the op compiled at the passed address.  Ops read their parameters from
+// memory as they execute so only a write to an op's opcode cell makes it stale.
+func (tc *threadedCode) invalidate(address int) {
+	if address < len(tc.ops) {
+		tc.ops[address] = nil
+	}
+}
+
+// operand returns a function loading the value of the parameter stored at the passed address
+func operand(address int, mode ParamMode) func(vm *VM) int {
+	if mode == ImmediateMode {
+		return func(vm *VM) int { return vm.immediateRead(address) }
+	}
+	return func(vm *VM) int { return vm.positionRead(address) }
+}
+
+// compile decodes the instruction at the passed address into an op whose parameter modes are
+// resolved once rather than every time it executes
+func (tc *threadedCode) compile(vm *VM, ip int) (*threadedOp, error) {
+	inst, modes, ok := decode(vm.memory[ip])
+	if !ok {
+		return nil, fmt.Errorf("%v encountered at position %v", decodeError(vm.memory[ip]), ip)
+	}
+	if err := vm.checkCompliance(inst, modes, ip); err != nil {
+		return nil, err
+	}
+	op := &threadedOp{inst: inst, modes: modes}
+	switch {
+	case inst.halts:
+	case inst.alu != nil && ip+3 < vm.Size():
+		alu := inst.alu
+		in1 := operand(ip+1, modes[0])
+		in2 := operand(ip+2, modes[1])
+		out := ip + 3
+		next := ip + inst.width
+		if modes[2] == PositionMode {
+			op.run = func(vm *VM) int {
+				vm.immediateWrite(vm.memory[out], alu(in1(vm), in2(vm)))
+				return next
+			}
+		} else {
+			op.run = func(vm *VM) int {
+				vm.immediateWrite(out, alu(in1(vm), in2(vm)))
+				return next
+			}
+		}
+	default:
+		exec := inst.exec
+		op.run = func(vm *VM) int { return exec(vm, ip, modes) }
+	}
+	for len(tc.ops) <= ip {
+		tc.ops = append(tc.ops, nil)
+	}
+	tc.ops[ip] = op
+	return op, nil
+}
+
+// executeThreaded executes the op compiled for the passed address, compiling it first if
+// needed, returning the address of the next instruction or whether the VM halted
+func (vm *VM) executeThreaded(ip int) (int, bool, error) {
+	var op *threadedOp
+	if ip < len(vm.threaded.ops) {
+		op = vm.threaded.ops[ip]
+	}
+	if op == nil {
+		var err error
+		if op, err = vm.threaded.compile(vm, ip); err != nil {
+			return ip, false, err
+		}
+	}
+	if vm.smc != nil {
+		vm.smc.markExecuted(ip, op.inst.width)
+	}
+	if len(vm.observers) > 0 {
+		vm.observeStep(ip, op.inst, op.modes)
+	}
+	if op.inst.halts {
+		return ip, true, nil
+	}
+	return op.run(vm), false, nil
+}
+
+// restore returns the VM's memory to the passed image discarding only the compiled code for
+// cells that changed, so that repeated runs of the same program keep most of their code
+func (vm *VM) restore(image []int) {
+	if len(image) != len(vm.memory) {
+		vm.memory = append([]int(nil), image...)
+		vm.threaded = nil
+		return
+	}
+	for address, val := range image {
+		if vm.memory[address] != val {
+			vm.memory[address] = val
+			if vm.threaded != nil {
+				vm.threaded.invalidate(address)
+			}
+		}
+	}
+}
+
+// SetEngine selects the engine used by subsequent runs
+func (vm *VM) SetEngine(engine Engine) {
+	vm.engine = engine
+}
+
+// Engine returns the engine used to run programs
+func (vm *VM) Engine() Engine {
+	return vm.engine
+}
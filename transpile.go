@@ -0,0 +1,384 @@
+/*
+ * Ahead-of-time Intcode to Go transpiler
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// goOperators maps the opcodes of instructions combining two inputs to the Go operator that
+// implements them.  Instructions missing from here are always left to the embedded interpreter.
+var goOperators = map[int]string{
+	1: "+",
+	2: "*",
+}
+
+// transpiledCase is one translated instruction of the generated program
+type transpiledCase struct {
+	Address   int    // address of the instruction
+	Comment   string // disassembly of the instruction
+	Statement string // Go statement implementing the instruction, empty to interpret it
+	Next      int    // address of the following instruction
+	Halts     bool   // whether the instruction halts the program
+}
+
+// transpiledOpcode is an opcode implemented by the generated program's embedded interpreter
+type transpiledOpcode struct {
+	Opcode   int
+	Mnemonic string
+	Operator string // Go operator for instructions combining two inputs, empty when halting
+	Width    int
+}
+
+// transpiledProgram holds everything needed to generate the Go source for a program
+type transpiledProgram struct {
+	Source  string             // name of the image the program was translated from
+	Image   string             // formatted image
+	Fixed   string             // formatted cells the translation treats as constants
+	Cases   []transpiledCase   // translated instructions
+	Opcodes []transpiledOpcode // opcodes known to the embedded interpreter
+}
+
+// transpileOperand returns a Go expression for the value of the passed parameter, reading the
+// parameter's cell at run time only if the program might change it
+func transpileOperand(si staticInstruction, param int, mutable map[int]bool) string {
+	cell := si.address + 1 + param
+	val := si.params[param]
+	switch {
+	case si.modes[param] == ImmediateMode && mutable[cell]:
+		return fmt.Sprintf("m[%d]", cell)
+	case si.modes[param] == ImmediateMode:
+		return strconv.Itoa(val)
+	case mutable[cell]:
+		return fmt.Sprintf("m[m[%d]]", cell)
+	default:
+		return fmt.Sprintf("m[%d]", val)
+	}
+}
+
+// transpileTarget returns a Go expression for the cell written by the passed parameter
+func transpileTarget(si staticInstruction, param int, mutable map[int]bool) string {
+	cell := si.address + 1 + param
+	switch {
+	case si.modes[param] == ImmediateMode:
+		return fmt.Sprintf("m[%d]", cell)
+	case mutable[cell]:
+		return fmt.Sprintf("m[m[%d]]", cell)
+	default:
+		return fmt.Sprintf("m[%d]", si.params[param])
+	}
+}
+
+// formatInts formats a list of integers as the body of a Go slice literal
+func formatInts(vals []int) string {
+	var b strings.Builder
+	for i, val := range vals {
+		if i%16 == 0 {
+			b.WriteString("\n\t")
+		} else {
+			b.WriteString(" ")
+		}
+		b.WriteString(strconv.Itoa(val))
+		b.WriteString(",")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Transpile writes a standalone Go program implementing the passed image to w.  Instructions
+// whose opcode cannot have been overwritten by the time they run become Go statements; the
+// rest, and anything reached that was not found statically, run on an embedded interpreter.
+// Cells listed in dynamic are treated as changing before the program runs, e.g. problem inputs.
+//
+// Every instruction advances the instruction pointer past itself so the instructions found by
+// sweeping the image run in order, each at most once.  A cell can therefore only differ from
+// the image when an instruction runs if it is dynamic or an earlier instruction wrote it.
+func Transpile(w io.Writer, source string, image []int, dynamic []int) error {
+
+	mutable := map[int]bool{} // cells that may differ from the image at this point
+	for _, address := range dynamic {
+		mutable[address] = true
+	}
+	anything := false // whether an earlier instruction may have written to any cell
+
+	program := transpiledProgram{Source: source, Image: formatInts(image)}
+	fixed := []int{}
+	for _, si := range sweep(image) {
+		if si.inst.extended {
+			return fmt.Errorf("%s at address %d is a registered opcode the transpiled program cannot run", si.inst.mnemonic, si.address)
+		}
+		c := transpiledCase{
+			Address: si.address,
+			Comment: disassemble(si),
+			Next:    si.end(),
+			Halts:   si.inst.halts,
+		}
+		operator, ok := goOperators[si.inst.opcode]
+		compiled := !anything && !mutable[si.address] && (ok || si.inst.halts)
+		if compiled {
+			fixed = append(fixed, si.address)
+			if si.inst.halts {
+				c.Statement = "return nil"
+			} else {
+				c.Statement = fmt.Sprintf("%s = %s %s %s",
+					transpileTarget(si, 2, mutable),
+					transpileOperand(si, 0, mutable), operator, transpileOperand(si, 1, mutable))
+				for param := range si.params {
+					if cell := si.address + 1 + param; !mutable[cell] {
+						fixed = append(fixed, cell)
+					}
+				}
+			}
+		}
+		program.Cases = append(program.Cases, c)
+
+		// Note what this instruction may write for the instructions that follow it
+		if !compiled {
+			anything = true
+		}
+		for _, param := range si.inst.writes {
+			if si.modes[param] == PositionMode && mutable[si.address+1+param] {
+				anything = true
+			}
+			mutable[si.writeTarget(param)] = true
+		}
+	}
+	sort.Ints(fixed)
+	program.Fixed = formatInts(fixed)
+
+	for opcode, inst := range instructions {
+		operator, ok := goOperators[opcode]
+		if !ok && !inst.halts {
+			continue
+		}
+		program.Opcodes = append(program.Opcodes, transpiledOpcode{
+			Opcode:   opcode,
+			Mnemonic: inst.mnemonic,
+			Operator: operator,
+			Width:    inst.width,
+		})
+	}
+	sort.Slice(program.Opcodes, func(i, j int) bool {
+		return program.Opcodes[i].Opcode < program.Opcodes[j].Opcode
+	})
+
+	var buf bytes.Buffer
+	if err := transpileTemplate.Execute(&buf, program); err != nil {
+		return err
+	}
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("generated source does not parse: %v", err)
+	}
+	_, err = w.Write(formatted)
+	return err
+}
+
+// transpileTool translates the Intcode image named by the first argument into a Go program
+// written to the file named by the second argument, or to standard output if there is none.
+// A '-dynamic' option with a comma separated list of addresses marks cells as program inputs.
+func transpileTool(args []string) int {
+
+	dynamic := []int{}
+	files := []string{}
+	for i := 0; i < len(args); i++ {
+		if args[i] != "-dynamic" {
+			files = append(files, args[i])
+			continue
+		}
+		if i+1 == len(args) {
+			fmt.Println("Please provide a list of addresses after '-dynamic'")
+			return 2
+		}
+		i++
+		for _, field := range strings.Split(args[i], ",") {
+			address, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return 2
+			}
+			dynamic = append(dynamic, address)
+		}
+	}
+	if len(files) < 1 || len(files) > 2 {
+		fmt.Println("Usage: transpile [-dynamic <addresses>] <image file> [<output file>]")
+		return 2
+	}
+
+	vm, err := new(VM).Load(files[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	out := io.Writer(os.Stdout)
+	if len(files) == 2 {
+		file, err := os.Create(files[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := Transpile(out, files[0], vm.memory, dynamic); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// transpileTemplate is the Go source generated for a program
+var transpileTemplate = template.Must(template.New("transpile").Parse(`// Code generated by transpiling {{.Source}}. DO NOT EDIT.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// image is the Intcode program translated from {{.Source}}
+var image = []int{ {{- .Image -}} }
+
+// fixed lists the cells the translation treats as constants so they cannot be changed with -set
+var fixed = []int{ {{- .Fixed -}} }
+
+// run executes the program held in m returning the error that stopped it, if any
+func run(m []int) (err error) {
+	ip := 0
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v at position %d", r, ip)
+		}
+	}()
+	for {
+		switch ip {
+{{- range .Cases}}
+		case {{.Address}}: // {{.Comment}}
+{{- if .Statement}}
+			{{.Statement}}
+{{- if not .Halts}}
+			ip = {{.Next}}
+{{- end}}
+{{- else}}
+			if ip = interpret(m, ip); ip < 0 {
+				return nil
+			}
+{{- end}}
+{{- end}}
+		default:
+			if ip = interpret(m, ip); ip < 0 {
+				return nil
+			}
+		}
+		if ip >= len(m) {
+			return fmt.Errorf("no halt instruction occured before end of memory")
+		}
+	}
+}
+
+// interpret executes the instruction at ip the way the Intcode VM would returning the address of
+// the next instruction, or -1 if the program halted
+func interpret(m []int, ip int) int {
+	val := m[ip]
+	if val < 0 {
+		panic(fmt.Sprintf("Invalid opcode %v", val))
+	}
+	modes := val / 100
+	param := func(n int) int {
+		mode := modes
+		for i := 0; i < n; i++ {
+			mode /= 10
+		}
+		switch mode % 10 {
+		case 0:
+			return m[ip+1+n]
+		case 1:
+			return ip + 1 + n
+		default:
+			panic(fmt.Sprintf("Invalid mode %d for parameter %d of %v", mode%10, n+1, val))
+		}
+	}
+	switch val % 100 {
+{{- range .Opcodes}}
+	case {{.Opcode}}: // {{.Mnemonic}}
+{{- if .Operator}}
+		m[param(2)] = m[param(0)] {{.Operator}} m[param(1)]
+		return ip + {{.Width}}
+{{- else}}
+		return -1
+{{- end}}
+{{- end}}
+	default:
+		panic(fmt.Sprintf("Invalid opcode %v", val%100))
+	}
+}
+
+func main() {
+	runs := flag.Int("n", 1, "number of times to run the program")
+	set := flag.String("set", "", "comma separated address=value pairs stored before each run")
+	flag.Parse()
+
+	// Parse the values to store before each run refusing cells compiled as constants
+	type poke struct{ address, val int }
+	pokes := []poke{}
+	for _, field := range strings.FieldsFunc(*set, func(r rune) bool { return r == ',' }) {
+		pair := strings.SplitN(field, "=", 2)
+		if len(pair) != 2 {
+			fmt.Printf("Error: '%s' is not of the form address=value\n", field)
+			os.Exit(2)
+		}
+		address, err := strconv.Atoi(pair[0])
+		if err != nil || address < 0 || address >= len(image) {
+			fmt.Printf("Error: '%s' is not an address in the program\n", pair[0])
+			os.Exit(2)
+		}
+		val, err := strconv.Atoi(pair[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(2)
+		}
+		for _, cell := range fixed {
+			if cell == address {
+				fmt.Printf("Error: address %d was compiled as a constant--transpile with -dynamic %d\n", address, address)
+				os.Exit(2)
+			}
+		}
+		pokes = append(pokes, poke{address, val})
+	}
+
+	m := make([]int, len(image))
+	start := time.Now()
+	for i := 0; i < *runs; i++ {
+		copy(m, image)
+		for _, p := range pokes {
+			m[p.address] = p.val
+		}
+		if err := run(m); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	elapsed := time.Since(start)
+
+	fmt.Printf("Address 0: %d\n", m[0])
+	if *runs > 0 {
+		fmt.Printf("%d runs in %v (%v per run)\n", *runs, elapsed, elapsed/time.Duration(*runs))
+	}
+}
+`))
@@ -1,11 +1,8 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
-	"os"
-	"strings"
 )
 
 /*
@@ -29,13 +26,11 @@ func scanCommas(data []byte, atEOF bool) (advance int, token []byte, err error)
 	return 0, nil, nil
 }
 
-// prompt displays a prompt on the console and then returns the string input
-func prompt(query, promptstr string) string {
-	reader := bufio.NewReader(os.Stdin)
+// prompt displays a prompt on the console and then returns the string input, or io.EOF once
+// there is no more input
+func prompt(query, promptstr string) (string, error) {
 	if len(query) > 0 {
 		fmt.Println(query)
 	}
-	fmt.Printf("%s ", promptstr)
-	text, _ := reader.ReadString('\n')
-	return strings.Replace(text, "\n", "", -1)
+	return stdin.readLine(promptstr + " ")
 }
@@ -0,0 +1,105 @@
+/*
+ * Input and output for the ship's computer
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoInput is reported when a program reads input but none is queued
+var ErrNoInput = errors.New("no input available")
+
+// QueueInput adds values to the end of the queue the program reads input from
+func (vm *VM) QueueInput(vals ...int) {
+	vm.inputs = append(vm.inputs, vals...)
+}
+
+// PendingInputs returns the values queued but not yet read by the program
+func (vm *VM) PendingInputs() []int {
+	return vm.inputs
+}
+
+// SetInputFallback sets a function supplying input when the program reads with nothing queued,
+// such as one asking the user.  Its error stops the run.  Pass nil to report ErrNoInput instead.
+func (vm *VM) SetInputFallback(fallback func() (int, error)) {
+	vm.inputFunc = fallback
+}
+
+// Outputs returns the values written by the program since its outputs were last cleared
+func (vm *VM) Outputs() []int {
+	return vm.outputs
+}
+
+// ClearOutputs discards the values written by the program
+func (vm *VM) ClearOutputs() {
+	vm.outputs = nil
+}
+
+// ResetIO discards any queued input and any output
+func (vm *VM) ResetIO() {
+	vm.inputs = nil
+	vm.outputs = nil
+}
+
+// input takes the next value from the input queue for the running program
+func (vm *VM) input() (int, error) {
+	var val int
+	switch {
+	case len(vm.inputs) > 0:
+		val = vm.inputs[0]
+		vm.inputs = vm.inputs[1:]
+	case vm.inputFunc != nil:
+		var err error
+		if val, err = vm.inputFunc(); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, ErrNoInput
+	}
+	for _, o := range vm.observers {
+		o.OnInput(vm, val)
+	}
+	if vm.loops != nil {
+		vm.loops.reset()
+	}
+	return val, nil
+}
+
+// output records a value written by the running program
+func (vm *VM) output(val int) {
+	for _, o := range vm.observers {
+		o.OnOutput(vm, val)
+	}
+	vm.outputs = append(vm.outputs, val)
+	if vm.loops != nil {
+		vm.loops.reset()
+	}
+}
+
+// asciiView renders values as text, showing those that are not printable ASCII characters or
+// newlines as their numbers in brackets
+func asciiView(vals []int) string {
+	var b strings.Builder
+	for _, val := range vals {
+		if val == '\n' || (val >= ' ' && val <= '~') {
+			b.WriteByte(byte(val))
+		} else {
+			fmt.Fprintf(&b, "[%d]", val)
+		}
+	}
+	return b.String()
+}
+
+// printOutputs shows the values written by the program both as numbers and as ASCII text
+func printOutputs(vm *VM) {
+	if len(vm.Outputs()) == 0 {
+		fmt.Println("No outputs")
+		return
+	}
+	fmt.Printf("Outputs (%d): %v\n", len(vm.Outputs()), vm.Outputs())
+	fmt.Printf("ASCII:\n%s\n", asciiView(vm.Outputs()))
+}